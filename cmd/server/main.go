@@ -1,24 +1,54 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 
+	"chat-app/internal/audit"
 	"chat-app/internal/auth"
+	"chat-app/internal/certs"
+	"chat-app/internal/cluster"
 	"chat-app/internal/config"
 	"chat-app/internal/database"
 	"chat-app/internal/handlers"
+	"chat-app/internal/metrics"
+	"chat-app/internal/ratelimit"
 	"chat-app/internal/services"
+	"chat-app/internal/tracing"
 	"chat-app/internal/websocket"
 	"chat-app/pkg/logger"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flag.Parse()
+
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logger.Fatal("Invalid configuration:\n%v", err)
+	}
+
+	// Initialize logging
+	if err := logger.Setup(cfg.Log.Level, cfg.Log.Format, os.Stdout); err != nil {
+		logger.Fatal("Invalid logging configuration: %v", err)
+	}
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	// Initialize database
 	db, err := database.NewPostgresDB(cfg.Database.URL)
@@ -26,54 +56,239 @@ func main() {
 		logger.Fatal("Failed to connect to database: %v", err)
 	}
 	defer db.Close()
+	db.SetActiveSessionTTL(cfg.Database.ActiveSessionTTL)
+	metrics.RegisterPoolStats(db.Stat)
+
+	// Initialize login rate limiter
+	var limitStore ratelimit.Store
+	switch cfg.RateLimit.Store {
+	case "postgres":
+		limitStore = db
+	case "memory":
+		limitStore = ratelimit.NewMemoryStore()
+	default:
+		logger.Fatal("Unknown rate limit store: %s", cfg.RateLimit.Store)
+	}
+	limiter := ratelimit.NewLimiter(limitStore, cfg.RateLimit)
+	go limiter.StartCleanupRoutine()
+
+	// Initialize audit log
+	auditRecorder := audit.NewRecorder(db)
 
 	// Initialize services
-	authService := auth.NewService(db, cfg)
-	roomService := services.NewRoomService(db)
+	authService := auth.NewService(db, cfg, limiter, auditRecorder)
+	roomService := services.NewRoomService(db, auditRecorder)
+	adminService := services.NewAdminService(db, auditRecorder)
+
+	// Relay hub broadcasts to other instances
+	busCtx, stopBus := context.WithCancel(context.Background())
+	defer stopBus()
+
+	bus, err := cluster.New(busCtx, cfg.Cluster, cfg.Database.URL)
+	if err != nil {
+		logger.Fatal("Failed to start %s message broker: %v", cfg.Cluster.Broker, err)
+	}
+	defer bus.Close()
+	go bus.Run(busCtx)
+	logger.Info("Using %s message broker as node %s", cfg.Cluster.Broker, bus.NodeID())
 
 	// Initialize WebSocket hub manager
-	hubManager := websocket.NewManager(db)
+	hubManager := websocket.NewManager(db, cfg.WebSocket, cfg.Flood, bus)
 
 	// Initialize handlers
 	authHandlers := handlers.NewAuthHandlers(authService)
 	roomHandlers := handlers.NewRoomHandlers(roomService, authService)
-	wsHandlers := handlers.NewWebSocketHandlers(authService, roomService, hubManager, db)
+	wsHandlers := handlers.NewWebSocketHandlers(authService, roomService, hubManager, db, cfg.WebSocket.Compression)
+	streamHandlers := handlers.NewStreamHandlers(authService, roomService, hubManager)
+	sessionHandlers := handlers.NewSessionHandlers(authService, hubManager)
+	adminHandlers := handlers.NewAdminHandlers(adminService, hubManager)
+	healthHandlers := handlers.NewHealthHandlers(db, hubManager, cfg.Server.ReadyTimeout, version)
 
 	// Setup routes
 	mux := http.NewServeMux()
-	setupRoutes(mux, authHandlers, roomHandlers, wsHandlers)
+	setupRoutes(mux, authService, authHandlers, roomHandlers, wsHandlers, streamHandlers, sessionHandlers, adminHandlers, healthHandlers)
+
+	var handler http.Handler = handlers.RequestIDMiddleware(corsMiddleware(handlers.ClientIPMiddleware(handlers.TracingMiddleware(handlers.MetricsMiddleware(mux)))))
+	if cfg.TLS.Enabled() && cfg.TLS.HSTSMaxAge > 0 {
+		handler = handlers.HSTSMiddleware(cfg.TLS.HSTSMaxAge, handler)
+	}
 
 	// Create server
 	server := &http.Server{
 		Addr:         cfg.Server.Port,
-		Handler:      corsMiddleware(mux),
+		Handler:      handler,
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 	}
 
+	// Serve HTTPS directly when a certificate is configured
+	scheme, wsScheme := "http", "ws"
+	if cfg.TLS.Enabled() {
+		reloader, err := certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			logger.Fatal("Failed to load TLS certificate: %v", err)
+		}
+		go reloader.Watch(cfg.TLS.ReloadInterval)
+
+		server.TLSConfig, err = certs.ServerConfig(cfg.TLS, reloader)
+		if err != nil {
+			logger.Fatal("Invalid TLS configuration: %v", err)
+		}
+		scheme, wsScheme = "https", "wss"
+	}
+
+	// Redirect plain HTTP to HTTPS
+	var redirectServer *http.Server
+	if cfg.TLS.RedirectPort != "" {
+		redirectServer = &http.Server{
+			Addr:         cfg.TLS.RedirectPort,
+			Handler:      redirectToHTTPS(cfg.Server.Port),
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+		}
+	}
+
 	// Start server
-	logger.Info("🚀 Server started on http://localhost%s", cfg.Server.Port)
-	logger.Info("📡 WebSocket endpoint: ws://localhost%s/ws", cfg.Server.Port)
+	logger.Info("🚀 Server started on %s://localhost%s", scheme, cfg.Server.Port)
+	logger.Info("📡 WebSocket endpoint: %s://localhost%s/ws", wsScheme, cfg.Server.Port)
 	printAPIEndpoints()
 
 	// Graceful shutdown
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Fatal("Server error: %v", err)
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shutdown
+	if redirectServer != nil {
+		logger.Info("↪️  Redirecting http://localhost%s to HTTPS", cfg.TLS.RedirectPort)
+		go func() {
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Redirect server error: %v", err)
+			}
+		}()
+	}
+
+	// Reload on SIGHUP, shut down gracefully on interrupt
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+
+	for running := true; running; {
+		select {
+		case <-hangup:
+			cfg = reloadConfig(*configPath, cfg, db, limiter, hubManager)
+		case <-quit:
+			running = false
+		}
+	}
 	logger.Info("Server shutting down...")
+	healthHandlers.BeginShutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Ask room clients to reconnect while in-flight requests finish: SSE
+	// streams and long polls are requests that only end once their hub
+	// drains, so the HTTP shutdown would otherwise wait for its timeout
+	hubsDrained := make(chan error, 1)
+	go func() {
+		hubsDrained <- hubManager.Shutdown(ctx, cfg.Server.ReconnectDelay)
+	}()
+
+	// Stop accepting connections and let in-flight requests finish
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			logger.Error("Redirect server shutdown: %v", err)
+		}
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("HTTP server shutdown: %v", err)
+	}
+
+	if err := <-hubsDrained; err != nil {
+		logger.Error("WebSocket shutdown: %v", err)
+	}
+	stopBus()
+
+	logger.Info("Server stopped")
 }
 
-func setupRoutes(mux *http.ServeMux, authHandlers *handlers.AuthHandlers, roomHandlers *handlers.RoomHandlers, wsHandlers *handlers.WebSocketHandlers) {
+// reloadConfig re-reads the configuration and applies the settings that can
+// change while running. An invalid configuration is rejected as a whole.
+func reloadConfig(path string, current *config.Config, db *database.PostgresDB, limiter *ratelimit.Limiter, hubManager *websocket.Manager) *config.Config {
+	next, err := config.Load(path)
+	if err != nil {
+		logger.Error("Config reload rejected:\n%v", err)
+		return current
+	}
+
+	if err := logger.SetLevel(next.Log.Level); err != nil {
+		logger.Error("Config reload rejected: %v", err)
+		return current
+	}
+	changed := current.RestartRequired(next)
+
+	// Keep startup-only settings as they are so they match what is running
+	next.Server.Port = current.Server.Port
+	next.Server.ReadTimeout = current.Server.ReadTimeout
+	next.Server.WriteTimeout = current.Server.WriteTimeout
+	next.Server.ReadyTimeout = current.Server.ReadyTimeout
+	next.TLS = current.TLS
+	next.Database.URL = current.Database.URL
+	next.JWT = current.JWT
+	next.MFA = current.MFA
+	next.RateLimit.Store = current.RateLimit.Store
+	next.Cluster = current.Cluster
+	next.WebSocket.Compression = current.WebSocket.Compression
+	next.Log.Format = current.Log.Format
+	next.Tracing = current.Tracing
+
+	db.SetActiveSessionTTL(next.Database.ActiveSessionTTL)
+	limiter.SetConfig(next.RateLimit)
+	hubManager.SetConfig(next.WebSocket, next.Flood)
+
+	if len(changed) > 0 {
+		logger.Warn("Config reload: restart required to apply %s", strings.Join(changed, ", "))
+	}
+	logger.Info("Configuration reloaded")
+	return next
+}
+
+func setupRoutes(mux *http.ServeMux, authService *auth.Service, authHandlers *handlers.AuthHandlers, roomHandlers *handlers.RoomHandlers, wsHandlers *handlers.WebSocketHandlers, streamHandlers *handlers.StreamHandlers, sessionHandlers *handlers.SessionHandlers, adminHandlers *handlers.AdminHandlers, healthHandlers *handlers.HealthHandlers) {
 	// Auth routes
 	mux.HandleFunc("/login", authHandlers.Login)
 	mux.HandleFunc("/register", authHandlers.Register)
+	mux.HandleFunc("/login/mfa", postOnly(authHandlers.VerifyMFA))
+	mux.HandleFunc("/login/reset-password", postOnly(authHandlers.ResetPassword))
+
+	// Two-factor authentication routes
+	mux.HandleFunc("/me/2fa/enroll", postOnly(authHandlers.EnrollTOTP))
+	mux.HandleFunc("/me/2fa/confirm", postOnly(authHandlers.ConfirmTOTP))
+	mux.HandleFunc("/me/2fa/disable", postOnly(authHandlers.DisableTOTP))
+
+	// Session management routes
+	mux.HandleFunc("/me/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sessionHandlers.ListSessions(w, r)
+	})
+	mux.HandleFunc("/me/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sessionHandlers.RevokeSession(w, r)
+	})
 
 	// Room routes
 	mux.HandleFunc("/rooms", func(w http.ResponseWriter, r *http.Request) {
@@ -81,7 +296,7 @@ func setupRoutes(mux *http.ServeMux, authHandlers *handlers.AuthHandlers, roomHa
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
-		
+
 		switch r.Method {
 		case http.MethodGet:
 			roomHandlers.ListRooms(w, r)
@@ -123,6 +338,28 @@ func setupRoutes(mux *http.ServeMux, authHandlers *handlers.AuthHandlers, roomHa
 			return
 		}
 
+		// /rooms/{id}/messages
+		if len(parts) == 4 && parts[3] == "messages" && r.Method == http.MethodGet {
+			roomHandlers.GetMessages(w, r)
+			return
+		}
+		if len(parts) == 4 && parts[3] == "messages" && r.Method == http.MethodPost {
+			streamHandlers.PostMessage(w, r)
+			return
+		}
+
+		// /rooms/{id}/events
+		if len(parts) == 4 && parts[3] == "events" && r.Method == http.MethodGet {
+			streamHandlers.Events(w, r)
+			return
+		}
+
+		// /rooms/{id}/poll
+		if len(parts) == 4 && parts[3] == "poll" && r.Method == http.MethodGet {
+			streamHandlers.Poll(w, r)
+			return
+		}
+
 		// /rooms/{id}/active
 		if len(parts) == 4 && parts[3] == "active" && r.Method == http.MethodGet {
 			roomHandlers.GetActiveUsers(w, r)
@@ -138,15 +375,88 @@ func setupRoutes(mux *http.ServeMux, authHandlers *handlers.AuthHandlers, roomHa
 		http.Error(w, "endpoint not found", http.StatusNotFound)
 	})
 
+	// Admin routes
+	mux.HandleFunc("/admin/stats", handlers.AdminOnly(authService, adminHandlers.GetStats))
+	mux.HandleFunc("/admin/users", handlers.AdminOnly(authService, adminHandlers.ListUsers))
+	mux.HandleFunc("/admin/audit", handlers.AdminOnly(authService, adminHandlers.QueryAuditLog))
+	mux.HandleFunc("/admin/audit/export", handlers.AdminOnly(authService, adminHandlers.ExportAuditLog))
+	mux.HandleFunc("/admin/log-level", handlers.AdminOnly(authService, adminHandlers.LogLevel))
+	mux.HandleFunc("/admin/users/", handlers.AdminOnly(authService, func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		if len(parts) != 5 || r.Method != http.MethodPost {
+			http.Error(w, "endpoint not found", http.StatusNotFound)
+			return
+		}
+
+		switch parts[4] {
+		case "deactivate":
+			adminHandlers.DeactivateUser(w, r)
+		case "reactivate":
+			adminHandlers.ReactivateUser(w, r)
+		case "force-password-reset":
+			adminHandlers.ForcePasswordReset(w, r)
+		case "grant-admin":
+			adminHandlers.GrantAdmin(w, r)
+		case "revoke-admin":
+			adminHandlers.RevokeAdmin(w, r)
+		default:
+			http.Error(w, "endpoint not found", http.StatusNotFound)
+		}
+	}))
+	mux.HandleFunc("/admin/rooms/", handlers.AdminOnly(authService, func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		if len(parts) != 4 || r.Method != http.MethodDelete {
+			http.Error(w, "endpoint not found", http.StatusNotFound)
+			return
+		}
+		adminHandlers.DeleteRoom(w, r)
+	}))
+
 	// WebSocket route
 	mux.HandleFunc("/ws", wsHandlers.HandleWebSocket)
+
+	// Prometheus metrics
+	mux.Handle("/metrics", metrics.Handler())
+
+	// Health probes
+	mux.HandleFunc("GET /healthz", healthHandlers.Liveness)
+	mux.HandleFunc("GET /readyz", healthHandlers.Readiness)
+	mux.HandleFunc("/status", handlers.AdminOnly(authService, healthHandlers.Status))
+}
+
+func postOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// redirectToHTTPS sends every request to the same host and path on the HTTPS
+// port.
+func redirectToHTTPS(httpsPort string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsPort)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Retry-After")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -161,11 +471,38 @@ func printAPIEndpoints() {
 	logger.Info("🔗 API endpoints:")
 	logger.Info("   POST /login")
 	logger.Info("   POST /register")
+	logger.Info("   POST /login/mfa")
+	logger.Info("   POST /login/reset-password")
+	logger.Info("   POST /me/2fa/enroll")
+	logger.Info("   POST /me/2fa/confirm")
+	logger.Info("   POST /me/2fa/disable")
+	logger.Info("   GET  /me/sessions")
+	logger.Info("   DELETE /me/sessions/{id}")
 	logger.Info("   GET  /rooms")
 	logger.Info("   POST /rooms")
 	logger.Info("   GET  /rooms/{id}/members")
 	logger.Info("   POST /rooms/{id}/invite")
 	logger.Info("   DELETE /rooms/{id}/leave")
 	logger.Info("   GET  /rooms/{id}/active")
+	logger.Info("   GET  /rooms/{id}/messages?after_seq=")
+	logger.Info("   POST /rooms/{id}/messages")
+	logger.Info("   GET  /rooms/{id}/events (SSE)")
+	logger.Info("   GET  /rooms/{id}/poll?after_seq=&poll_id=")
 	logger.Info("   DELETE /rooms/{id}")
-}
\ No newline at end of file
+	logger.Info("   GET  /metrics")
+	logger.Info("   GET  /healthz")
+	logger.Info("   GET  /readyz")
+	logger.Info("   GET  /status")
+	logger.Info("   GET  /admin/stats")
+	logger.Info("   GET  /admin/users?q=")
+	logger.Info("   POST /admin/users/{id}/deactivate")
+	logger.Info("   POST /admin/users/{id}/reactivate")
+	logger.Info("   POST /admin/users/{id}/force-password-reset")
+	logger.Info("   POST /admin/users/{id}/grant-admin")
+	logger.Info("   POST /admin/users/{id}/revoke-admin")
+	logger.Info("   GET  /admin/audit")
+	logger.Info("   GET  /admin/audit/export")
+	logger.Info("   GET  /admin/log-level")
+	logger.Info("   PUT  /admin/log-level")
+	logger.Info("   DELETE /admin/rooms/{id}")
+}
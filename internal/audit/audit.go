@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"chat-app/internal/database"
+	"chat-app/internal/models"
+	"chat-app/pkg/logger"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionLoginSuccess   = "auth.login.success"
+	ActionLoginFailure   = "auth.login.failure"
+	ActionLoginThrottled = "auth.login.throttled"
+	ActionRegister       = "auth.register"
+	ActionMFAEnabled     = "auth.mfa.enabled"
+	ActionMFADisabled    = "auth.mfa.disabled"
+	ActionPasswordReset  = "auth.password.reset"
+	ActionSessionRevoked = "auth.session.revoked"
+
+	ActionRoomCreate   = "room.create"
+	ActionRoomDelete   = "room.delete"
+	ActionRoomInvite   = "room.invite"
+	ActionMemberJoined = "room.member.added"
+	ActionMemberLeft   = "room.member.removed"
+
+	ActionRoleGranted        = "admin.role.granted"
+	ActionRoleRevoked        = "admin.role.revoked"
+	ActionUserDeactivated    = "admin.user.deactivated"
+	ActionUserReactivated    = "admin.user.reactivated"
+	ActionForcePasswordReset = "admin.user.force_password_reset"
+	ActionAdminRoomDelete    = "admin.room.delete"
+)
+
+// Target types for audit events.
+const (
+	TargetUser    = "user"
+	TargetRoom    = "room"
+	TargetSession = "session"
+)
+
+type contextKey string
+
+const clientIPKey contextKey = "client_ip"
+
+// WithClientIP attaches the caller's IP so events recorded further down the
+// call chain can include it.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// Recorder writes audit events. Failures are logged but never block the
+// action being audited.
+type Recorder struct {
+	db database.AuditRepository
+}
+
+func NewRecorder(db database.AuditRepository) *Recorder {
+	return &Recorder{db: db}
+}
+
+// Event describes a single audited action.
+type Event struct {
+	ActorID    int
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   string
+	Metadata   map[string]interface{}
+}
+
+func (r *Recorder) Record(ctx context.Context, event Event) {
+	auditEvent := &models.AuditEvent{
+		ActorName:  event.ActorName,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		IP:         ClientIP(ctx),
+	}
+	if event.ActorID != 0 {
+		actorID := event.ActorID
+		auditEvent.ActorID = &actorID
+	}
+
+	if len(event.Metadata) > 0 {
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error marshaling audit metadata: %v", err)
+		} else {
+			auditEvent.Metadata = metadata
+		}
+	}
+
+	// Record even if the request was cancelled after the action completed
+	if err := r.db.CreateAuditEvent(context.WithoutCancel(ctx), auditEvent); err != nil {
+		logger.ErrorContext(ctx, "Error recording audit event %s: %v", event.Action, err)
+	}
+}
+
+func (r *Recorder) Query(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	return r.db.ListAuditEvents(ctx, filter)
+}
+
+// Export streams every event matching the filter to fn, oldest first.
+func (r *Recorder) Export(ctx context.Context, filter models.AuditFilter, fn func(*models.AuditEvent) error) error {
+	const pageSize = 1000
+	filter.Limit = pageSize
+	filter.Offset = 0
+	filter.Ascending = true
+
+	// Page by ID so events recorded during the export don't shift pages
+	for {
+		events, err := r.db.ListAuditEvents(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+			filter.AfterID = event.ID
+		}
+
+		if len(events) < pageSize {
+			return nil
+		}
+	}
+}
+
+// ID formats a numeric target ID.
+func ID(id int) string {
+	return strconv.Itoa(id)
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"chat-app/internal/audit"
+	"chat-app/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	mfaTokenPurpose   = "mfa"
+	resetTokenPurpose = "password_reset"
+)
+
+// VerifyMFA completes a two-step login using the challenge token returned by
+// Login together with a TOTP code or an unused recovery code.
+func (s *Service) VerifyMFA(ctx context.Context, req *models.MFAVerifyRequest) (*models.LoginResponse, error) {
+	userID, err := s.parseChallengeToken(req.MFAToken, mfaTokenPurpose)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	if !user.IsActive {
+		s.recordLoginFailure(ctx, audit.ActionLoginFailure, user, user.Email, "account deactivated")
+		return nil, fmt.Errorf("account deactivated")
+	}
+
+	if !user.TOTPEnabled {
+		return nil, fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	// Codes are short, so they share the account's login throttle
+	if err := s.limiter.Allow(ctx, req.IP, user.Email); err != nil {
+		s.recordLoginFailure(ctx, audit.ActionLoginThrottled, user, user.Email, err.Error())
+		return nil, err
+	}
+
+	if err := s.verifySecondFactor(ctx, user, req.Code, req.RecoveryCode); err != nil {
+		s.limiter.RecordFailure(ctx, req.IP, user.Email)
+		s.recordLoginFailure(ctx, audit.ActionLoginFailure, user, user.Email, err.Error())
+		return nil, err
+	}
+
+	s.limiter.RecordSuccess(ctx, user.Email)
+	return s.finishLogin(ctx, user, req.DeviceInfo)
+}
+
+// EnrollTOTP generates a new TOTP secret for the user. The secret stays
+// inactive until it is confirmed with a valid code.
+func (s *Service) EnrollTOTP(ctx context.Context, user *models.User) (*models.TOTPEnrollResponse, error) {
+	if user.TOTPEnabled {
+		return nil, fmt.Errorf("two-factor authentication already enabled")
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	if err := s.db.SetTOTPSecret(ctx, user.ID, secret); err != nil {
+		return nil, fmt.Errorf("failed to store secret: %w", err)
+	}
+
+	return &models.TOTPEnrollResponse{
+		Secret: secret,
+		URI:    totpURI(s.cfg.MFA.Issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves their
+// authenticator is set up, and returns a fresh set of recovery codes.
+func (s *Service) ConfirmTOTP(ctx context.Context, user *models.User, code string) (*models.TOTPConfirmResponse, error) {
+	if user.TOTPEnabled {
+		return nil, fmt.Errorf("two-factor authentication already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return nil, fmt.Errorf("two-factor enrollment not started")
+	}
+
+	if err := s.useTOTP(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
+	if err := s.db.EnableTOTP(ctx, user.ID, hashes); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    user.ID,
+		ActorName:  user.Username,
+		Action:     audit.ActionMFAEnabled,
+		TargetType: audit.TargetUser,
+		TargetID:   audit.ID(user.ID),
+	})
+
+	return &models.TOTPConfirmResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableTOTP turns off two-factor authentication after re-authenticating the
+// user with their password and a current second factor.
+func (s *Service) DisableTOTP(ctx context.Context, user *models.User, req *models.TOTPDisableRequest) error {
+	if !user.TOTPEnabled {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		return fmt.Errorf("invalid credentials")
+	}
+
+	if err := s.verifySecondFactor(ctx, user, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+
+	if err := s.db.DisableTOTP(ctx, user.ID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    user.ID,
+		ActorName:  user.Username,
+		Action:     audit.ActionMFADisabled,
+		TargetType: audit.TargetUser,
+		TargetID:   audit.ID(user.ID),
+	})
+	return nil
+}
+
+func (s *Service) verifySecondFactor(ctx context.Context, user *models.User, code, recoveryCode string) error {
+	if code != "" {
+		return s.useTOTP(ctx, user, code)
+	}
+
+	if recoveryCode != "" {
+		used, err := s.db.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return fmt.Errorf("failed to verify recovery code: %w", err)
+		}
+		if used {
+			return nil
+		}
+		return fmt.Errorf("invalid recovery code")
+	}
+
+	return fmt.Errorf("verification code required")
+}
+
+// useTOTP accepts each code once: a code for a time step no later than the
+// last one accepted for the user is rejected, so it can't be replayed.
+func (s *Service) useTOTP(ctx context.Context, user *models.User, code string) error {
+	counter, ok := validateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return fmt.Errorf("invalid verification code")
+	}
+
+	used, err := s.db.UseTOTPCounter(ctx, user.ID, counter)
+	if err != nil {
+		return fmt.Errorf("failed to verify code: %w", err)
+	}
+	if !used {
+		return fmt.Errorf("invalid verification code")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"chat-app/internal/audit"
+	"chat-app/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ResetPassword completes a login that was interrupted by a forced password
+// reset. The new password must differ from the old one.
+func (s *Service) ResetPassword(ctx context.Context, req *models.PasswordResetRequest) (*models.LoginResponse, error) {
+	userID, err := s.parseChallengeToken(req.ResetToken, resetTokenPurpose)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	if !user.IsActive {
+		return nil, fmt.Errorf("account deactivated")
+	}
+	if !user.MustReset {
+		return nil, fmt.Errorf("password reset not required")
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.NewPassword)) == nil {
+		return nil, fmt.Errorf("new password must differ from the current one")
+	}
+
+	if err := s.db.UpdatePassword(ctx, user.ID, req.NewPassword); err != nil {
+		return nil, fmt.Errorf("failed to update password: %w", err)
+	}
+	user.MustReset = false
+
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    user.ID,
+		ActorName:  user.Username,
+		Action:     audit.ActionPasswordReset,
+		TargetType: audit.TargetUser,
+		TargetID:   audit.ID(user.ID),
+	})
+
+	return s.finishLogin(ctx, user, req.DeviceInfo)
+}
@@ -7,23 +7,29 @@ import (
 	"strings"
 	"time"
 
+	"chat-app/internal/audit"
 	"chat-app/internal/config"
 	"chat-app/internal/database"
 	"chat-app/internal/models"
+	"chat-app/internal/ratelimit"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Service struct {
-	db  database.Database
-	cfg *config.Config
+	db      database.Database
+	cfg     *config.Config
+	limiter *ratelimit.Limiter
+	audit   *audit.Recorder
 }
 
-func NewService(db database.Database, cfg *config.Config) *Service {
+func NewService(db database.Database, cfg *config.Config, limiter *ratelimit.Limiter, auditRecorder *audit.Recorder) *Service {
 	return &Service{
-		db:  db,
-		cfg: cfg,
+		db:      db,
+		cfg:     cfg,
+		limiter: limiter,
+		audit:   auditRecorder,
 	}
 }
 
@@ -39,46 +45,141 @@ func (s *Service) Register(ctx context.Context, req *models.RegisterRequest) (*m
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
-	// Generate token
-	token, err := s.generateToken(user)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
-	}
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    user.ID,
+		ActorName:  user.Username,
+		Action:     audit.ActionRegister,
+		TargetType: audit.TargetUser,
+		TargetID:   audit.ID(user.ID),
+	})
 
-	return &models.LoginResponse{
-		Token: token,
-		User:  *user,
-	}, nil
+	return s.completeLogin(ctx, user, req.DeviceInfo)
 }
 
 func (s *Service) Login(ctx context.Context, req *models.LoginRequest) (*models.LoginResponse, error) {
+	// Throttle by IP and account before doing any expensive work
+	if err := s.limiter.Allow(ctx, req.IP, req.Email); err != nil {
+		s.recordLoginFailure(ctx, audit.ActionLoginThrottled, nil, req.Email, err.Error())
+		return nil, err
+	}
+
 	// Get user by email
 	user, err := s.db.GetUserByEmail(ctx, req.Email)
 	if err != nil {
+		s.limiter.RecordFailure(ctx, req.IP, req.Email)
+		s.recordLoginFailure(ctx, audit.ActionLoginFailure, nil, req.Email, "unknown email")
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		s.limiter.RecordFailure(ctx, req.IP, req.Email)
+		s.recordLoginFailure(ctx, audit.ActionLoginFailure, user, req.Email, "wrong password")
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
+	if !user.IsActive {
+		s.recordLoginFailure(ctx, audit.ActionLoginFailure, user, req.Email, "account deactivated")
+		return nil, fmt.Errorf("account deactivated")
+	}
+
+	// Require a second factor before issuing a full token
+	if user.TOTPEnabled {
+		mfaToken, err := s.generateChallengeToken(user, mfaTokenPurpose)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate MFA challenge: %w", err)
+		}
+
+		return &models.LoginResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		}, nil
+	}
+
+	s.limiter.RecordSuccess(ctx, req.Email)
+	return s.finishLogin(ctx, user, req.DeviceInfo)
+}
+
+// finishLogin runs once all credentials are verified. Users flagged for a
+// password reset get a reset challenge instead of a session.
+func (s *Service) finishLogin(ctx context.Context, user *models.User, device models.DeviceInfo) (*models.LoginResponse, error) {
+	if user.MustReset {
+		resetToken, err := s.generateChallengeToken(user, resetTokenPurpose)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate reset challenge: %w", err)
+		}
+
+		return &models.LoginResponse{
+			ResetRequired: true,
+			ResetToken:    resetToken,
+		}, nil
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    user.ID,
+		ActorName:  user.Username,
+		Action:     audit.ActionLoginSuccess,
+		TargetType: audit.TargetUser,
+		TargetID:   audit.ID(user.ID),
+		Metadata:   map[string]interface{}{"mfa": user.TOTPEnabled},
+	})
+
+	return s.completeLogin(ctx, user, device)
+}
+
+func (s *Service) recordLoginFailure(ctx context.Context, action string, user *models.User, email, reason string) {
+	event := audit.Event{
+		ActorName:  email,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		Metadata:   map[string]interface{}{"reason": reason},
+	}
+	if user != nil {
+		event.ActorID = user.ID
+		event.TargetID = audit.ID(user.ID)
+	}
+
+	s.audit.Record(ctx, event)
+}
+
+func (s *Service) completeLogin(ctx context.Context, user *models.User, device models.DeviceInfo) (*models.LoginResponse, error) {
+	// Track the token as a session for this device
+	session, err := s.createSession(ctx, user, device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
 	// Generate token
-	token, err := s.generateToken(user)
+	token, err := s.generateToken(user, session)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
 	// Remove sensitive data
 	user.PasswordHash = ""
+	user.TOTPSecret = ""
 
 	return &models.LoginResponse{
 		Token: token,
-		User:  *user,
+		User:  user,
 	}, nil
 }
 
 func (s *Service) ValidateToken(tokenString string) (*jwt.MapClaims, error) {
+	claims, err := s.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// MFA challenge tokens must not be usable as access tokens
+	if purpose, ok := (*claims)["purpose"]; ok && purpose != "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return claims, nil
+}
+
+func (s *Service) parseToken(tokenString string) (*jwt.MapClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -98,26 +199,17 @@ func (s *Service) ValidateToken(tokenString string) (*jwt.MapClaims, error) {
 }
 
 func (s *Service) GetUserFromToken(ctx context.Context, tokenString string) (*models.User, error) {
-	claims, err := s.ValidateToken(tokenString)
-	if err != nil {
-		return nil, err
-	}
-
-	userIDFloat, ok := (*claims)["user_id"].(float64)
-	if !ok {
-		return nil, fmt.Errorf("invalid user ID in token")
-	}
-
-	userID := int(userIDFloat)
-	return s.db.GetUserByID(ctx, userID)
+	user, _, err := s.Authenticate(ctx, tokenString)
+	return user, err
 }
 
-func (s *Service) generateToken(user *models.User) (string, error) {
+func (s *Service) generateToken(user *models.User, session *models.UserSession) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id":  user.ID,
 		"username": user.Username,
 		"email":    user.Email,
-		"exp":      time.Now().Add(s.cfg.JWT.ExpiresIn).Unix(),
+		"sid":      session.ID,
+		"exp":      session.ExpiresAt.Unix(),
 		"iat":      time.Now().Unix(),
 	}
 
@@ -125,6 +217,38 @@ func (s *Service) generateToken(user *models.User) (string, error) {
 	return token.SignedString(s.cfg.JWT.Secret)
 }
 
+func (s *Service) generateChallengeToken(user *models.User, purpose string) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": user.ID,
+		"purpose": purpose,
+		"exp":     time.Now().Add(s.cfg.MFA.ChallengeTTL).Unix(),
+		"iat":     time.Now().Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(s.cfg.JWT.Secret)
+}
+
+// parseChallengeToken validates a short-lived challenge token issued for the
+// given purpose and returns the user ID it was issued to.
+func (s *Service) parseChallengeToken(tokenString, purpose string) (int, error) {
+	claims, err := s.parseToken(tokenString)
+	if err != nil {
+		return 0, fmt.Errorf("invalid or expired challenge token")
+	}
+
+	if tokenPurpose, _ := (*claims)["purpose"].(string); tokenPurpose != purpose {
+		return 0, fmt.Errorf("invalid or expired challenge token")
+	}
+
+	userIDFloat, ok := (*claims)["user_id"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("invalid user ID in token")
+	}
+
+	return int(userIDFloat), nil
+}
+
 func (s *Service) validateRegistrationRequest(req *models.RegisterRequest) error {
 	if req.Username == "" || req.Email == "" || req.Password == "" {
 		return fmt.Errorf("missing required fields")
@@ -136,8 +260,8 @@ func (s *Service) validateRegistrationRequest(req *models.RegisterRequest) error
 	}
 
 	// Validate password strength
-	if len(req.Password) < 8 {
-		return fmt.Errorf("password must be at least 8 characters long")
+	if err := validatePassword(req.Password); err != nil {
+		return err
 	}
 
 	// Sanitize and validate username
@@ -152,4 +276,11 @@ func (s *Service) validateRegistrationRequest(req *models.RegisterRequest) error
 func isValidEmail(email string) bool {
 	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 	return emailRegex.MatchString(email)
+}
+
+func validatePassword(password string) error {
+	if len(password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters long")
+	}
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"chat-app/internal/audit"
+	"chat-app/internal/models"
+	"chat-app/pkg/logger"
+)
+
+// sessionTouchInterval is how stale a session's last use time may get before
+// a request refreshes it, so busy clients don't write on every request.
+const sessionTouchInterval = time.Minute
+
+// Authenticate validates an access token and the session it was issued for,
+// returning the user and the session ID.
+func (s *Service) Authenticate(ctx context.Context, tokenString string) (*models.User, string, error) {
+	claims, err := s.ValidateToken(tokenString)
+	if err != nil {
+		return nil, "", err
+	}
+
+	userIDFloat, ok := (*claims)["user_id"].(float64)
+	if !ok {
+		return nil, "", fmt.Errorf("invalid user ID in token")
+	}
+
+	sessionID, ok := (*claims)["sid"].(string)
+	if !ok || sessionID == "" {
+		return nil, "", fmt.Errorf("invalid session in token")
+	}
+
+	// Revoked or expired sessions invalidate the token immediately
+	session, err := s.db.GetUserSession(ctx, sessionID)
+	if err != nil || session.UserID != int(userIDFloat) {
+		return nil, "", fmt.Errorf("session expired or revoked")
+	}
+
+	if time.Since(session.LastUsedAt) >= sessionTouchInterval {
+		if err := s.db.TouchUserSession(ctx, sessionID); err != nil {
+			logger.ErrorContext(ctx, "Error updating session last used time: %v", err)
+		}
+	}
+
+	user, err := s.db.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !user.IsActive {
+		return nil, "", fmt.Errorf("account deactivated")
+	}
+
+	return user, sessionID, nil
+}
+
+func (s *Service) ListSessions(ctx context.Context, userID int, currentSessionID string) ([]*models.UserSession, error) {
+	sessions, err := s.db.ListUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, session := range sessions {
+		session.Current = session.ID == currentSessionID
+	}
+
+	return sessions, nil
+}
+
+func (s *Service) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	if err := s.db.RevokeUserSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionSessionRevoked,
+		TargetType: audit.TargetSession,
+		TargetID:   sessionID,
+	})
+	return nil
+}
+
+func (s *Service) createSession(ctx context.Context, user *models.User, device models.DeviceInfo) (*models.UserSession, error) {
+	sessionID, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	deviceName := device.DeviceName
+	if deviceName == "" {
+		deviceName = "Unknown device"
+	}
+
+	session := &models.UserSession{
+		ID:         sessionID,
+		UserID:     user.ID,
+		DeviceName: deviceName,
+		UserAgent:  device.UserAgent,
+		IP:         device.IP,
+		ExpiresAt:  time.Now().Add(s.cfg.JWT.ExpiresIn),
+	}
+
+	if err := s.db.CreateUserSession(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func generateSessionID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", bytes), nil
+}
@@ -0,0 +1,264 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"chat-app/internal/config"
+	"chat-app/internal/metrics"
+	"chat-app/pkg/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// publishQueue bounds the events waiting to be sent or delivered, and
+// roomQueue those waiting for one room's handler.
+const (
+	publishQueue = 1024
+	roomQueue    = 256
+)
+
+// Handler receives an event published by another instance for a room.
+type Handler func(ctx context.Context, data []byte)
+
+// Broker carries room broadcasts between server instances. Each instance
+// delivers its own broadcasts locally, so brokers only hand subscribers the
+// events published by other nodes. Hubs don't route their own broadcasts
+// through the broker: local clients never wait on a round trip, and a broker
+// outage only cuts off the other instances.
+type Broker interface {
+	// NodeID returns the ID this instance stamps on its events.
+	NodeID() string
+	// Publish sends data to the room's subscribers on other instances. It must
+	// not block the caller.
+	Publish(ctx context.Context, room int, data []byte)
+	// Subscribe delivers the room's remote events to handler until
+	// Unsubscribe is called for the room. It returns once the subscription is
+	// active, so every event published from then on is delivered. Each room's
+	// events are handed over in order, and a slow handler holds up only its
+	// own room.
+	Subscribe(ctx context.Context, room int, handler Handler) error
+	Unsubscribe(room int)
+	// Run processes traffic until ctx is done.
+	Run(ctx context.Context)
+	Close()
+}
+
+// New creates the broker selected by cfg.
+func New(ctx context.Context, cfg config.ClusterConfig, databaseURL string) (Broker, error) {
+	nodeID := cfg.NodeID
+	if nodeID == "" {
+		nodeID = NewNodeID()
+	}
+
+	switch cfg.Broker {
+	case "memory":
+		return NewMemoryBroker(nodeID), nil
+	case "postgres":
+		return NewPostgresBus(ctx, databaseURL, nodeID)
+	case "nats":
+		return NewNATSBroker(cfg.NATSURL, nodeID)
+	case "redis":
+		return NewRedisBroker(ctx, cfg.RedisURL, nodeID)
+	default:
+		return nil, fmt.Errorf("unknown cluster broker %q", cfg.Broker)
+	}
+}
+
+// event is the wire format of a relayed hub broadcast.
+type event struct {
+	Node  string                 `json:"node"`
+	Room  int                    `json:"room"`
+	Data  []byte                 `json:"data,omitempty"`
+	Ref   int64                  `json:"ref,omitempty"`
+	Trace propagation.MapCarrier `json:"trace,omitempty"`
+}
+
+func newEvent(ctx context.Context, nodeID string, room int, data []byte) event {
+	evt := event{
+		Node:  nodeID,
+		Room:  room,
+		Data:  data,
+		Trace: propagation.MapCarrier{},
+	}
+	otel.GetTextMapPropagator().Inject(ctx, evt.Trace)
+	return evt
+}
+
+func decodeEvent(payload []byte) (event, bool) {
+	var evt event
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		metrics.ClusterDropped.WithLabelValues("invalid").Inc()
+		logger.Error("Invalid cluster event: %v", err)
+		return evt, false
+	}
+	return evt, true
+}
+
+// subscriptions tracks room handlers for a broker and filters out the node's
+// own events. Each room has a queue and a goroutine delivering from it.
+type subscriptions struct {
+	nodeID string
+	// load, if set, fills in the data of events that only carry a reference
+	// to it.
+	load     func(ctx context.Context, evt *event) error
+	mutex    sync.Mutex
+	handlers map[int]*roomSubscription
+}
+
+type roomSubscription struct {
+	handler Handler
+	queue   chan event
+	stop    chan struct{}
+}
+
+func newSubscriptions(nodeID string) *subscriptions {
+	return &subscriptions{
+		nodeID:   nodeID,
+		handlers: make(map[int]*roomSubscription),
+	}
+}
+
+func (s *subscriptions) set(room int, handler Handler) {
+	sub := &roomSubscription{
+		handler: handler,
+		queue:   make(chan event, roomQueue),
+		stop:    make(chan struct{}),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if old, exists := s.handlers[room]; exists {
+		close(old.stop)
+	}
+	s.handlers[room] = sub
+	go s.deliverLoop(sub)
+}
+
+func (s *subscriptions) remove(room int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sub, exists := s.handlers[room]; exists {
+		close(sub.stop)
+		delete(s.handlers, room)
+	}
+}
+
+func (s *subscriptions) rooms() []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rooms := make([]int, 0, len(s.handlers))
+	for room := range s.handlers {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// dispatch queues evt for its room unless it came from this node. Events for
+// a room whose queue is full are dropped.
+func (s *subscriptions) dispatch(evt event) {
+	// Our own events were already delivered locally
+	if evt.Node == s.nodeID {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sub, ok := s.handlers[evt.Room]
+	if !ok {
+		return
+	}
+	select {
+	case sub.queue <- evt:
+	default:
+		metrics.ClusterDropped.WithLabelValues("room_queue_full").Inc()
+	}
+}
+
+func (s *subscriptions) deliverLoop(sub *roomSubscription) {
+	for {
+		select {
+		case evt := <-sub.queue:
+			ctx := otel.GetTextMapPropagator().Extract(context.Background(), evt.Trace)
+			if evt.Ref != 0 && s.load != nil {
+				if err := s.load(ctx, &evt); err != nil {
+					metrics.ClusterDropped.WithLabelValues("payload_missing").Inc()
+					logger.Error("Error loading cluster payload %d: %v", evt.Ref, err)
+					continue
+				}
+			}
+			metrics.ClusterReceived.Inc()
+			sub.handler(ctx, evt.Data)
+		case <-sub.stop:
+			return
+		}
+	}
+}
+
+// confirmations lets Subscribe wait until a broker has confirmed that it
+// receives a room's events.
+type confirmations struct {
+	mutex   sync.Mutex
+	waiting map[int][]chan struct{}
+}
+
+func newConfirmations() *confirmations {
+	return &confirmations{waiting: make(map[int][]chan struct{})}
+}
+
+// await calls subscribe and waits until the room is confirmed or ctx is done.
+func (c *confirmations) await(ctx context.Context, room int, subscribe func() error) error {
+	confirmed := make(chan struct{})
+	c.mutex.Lock()
+	c.waiting[room] = append(c.waiting[room], confirmed)
+	c.mutex.Unlock()
+
+	err := subscribe()
+	if err == nil {
+		select {
+		case <-confirmed:
+			return nil
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.waiting[room] = slices.DeleteFunc(c.waiting[room], func(ch chan struct{}) bool { return ch == confirmed })
+	if len(c.waiting[room]) == 0 {
+		delete(c.waiting, room)
+	}
+	return err
+}
+
+// confirm releases everyone waiting for the room.
+func (c *confirmations) confirm(room int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, confirmed := range c.waiting[room] {
+		close(confirmed)
+	}
+	delete(c.waiting, room)
+}
+
+// NewNodeID returns an ID unique to this process, prefixed with the host name
+// to make it recognisable in logs.
+func NewNodeID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "node"
+	}
+
+	bytes := make([]byte, 4)
+	rand.Read(bytes)
+	return fmt.Sprintf("%s-%x", strings.ToLower(host), bytes)
+}
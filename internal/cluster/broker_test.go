@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const waitTimeout = 2 * time.Second
+
+// received collects the events a handler was given.
+type received chan string
+
+func (r received) handler(ctx context.Context, data []byte) {
+	r <- string(data)
+}
+
+func (r received) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-r:
+		if got != want {
+			t.Fatalf("received %q, want %q", got, want)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatalf("timed out waiting for %q", want)
+	}
+}
+
+func (r received) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-r:
+		t.Fatalf("received unexpected %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// runBroker runs b until the test ends.
+func runBroker(t *testing.T, b Broker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		b.Close()
+	})
+	go b.Run(ctx)
+}
+
+func subscribe(t *testing.T, b Broker, room int, handler Handler) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	if err := b.Subscribe(ctx, room, handler); err != nil {
+		t.Fatalf("subscribe to room %d: %v", room, err)
+	}
+}
+
+// testRelay checks that an event published on one node reaches the room's
+// subscribers on the other nodes only.
+func testRelay(t *testing.T, publisher Broker, peers ...Broker) {
+	own := make(received, 1)
+	subscribe(t, publisher, 1, own.handler)
+
+	var inbox []received
+	for _, peer := range peers {
+		room1, room2 := make(received, 1), make(received, 1)
+		subscribe(t, peer, 1, room1.handler)
+		subscribe(t, peer, 2, room2.handler)
+		inbox = append(inbox, room1, room2)
+	}
+
+	publisher.Publish(context.Background(), 1, []byte("hello"))
+	for i := 0; i < len(inbox); i += 2 {
+		inbox[i].expect(t, "hello")
+		inbox[i+1].expectNone(t)
+	}
+	own.expectNone(t)
+}
+
+func newMemoryBrokers(t *testing.T, n int) []*MemoryBroker {
+	network := NewMemoryNetwork()
+	brokers := make([]*MemoryBroker, n)
+	for i := range brokers {
+		brokers[i] = network.Broker(fmt.Sprintf("node-%d", i))
+		runBroker(t, brokers[i])
+	}
+	return brokers
+}
+
+func TestMemoryBrokerRelaysToOtherNodes(t *testing.T) {
+	brokers := newMemoryBrokers(t, 3)
+	testRelay(t, brokers[0], brokers[1], brokers[2])
+}
+
+func TestMemoryBrokerUnsubscribe(t *testing.T) {
+	brokers := newMemoryBrokers(t, 2)
+	events := make(received, 1)
+	subscribe(t, brokers[1], 1, events.handler)
+
+	brokers[1].Unsubscribe(1)
+	brokers[0].Publish(context.Background(), 1, []byte("hello"))
+	events.expectNone(t)
+}
+
+func TestMemoryBrokerClosedNodeLeavesNetwork(t *testing.T) {
+	brokers := newMemoryBrokers(t, 3)
+	closed, open := make(received, 1), make(received, 1)
+	subscribe(t, brokers[1], 1, closed.handler)
+	subscribe(t, brokers[2], 1, open.handler)
+
+	brokers[1].Close()
+	brokers[0].Publish(context.Background(), 1, []byte("hello"))
+	open.expect(t, "hello")
+	closed.expectNone(t)
+}
+
+func TestBrokerDeliversRoomEventsInOrder(t *testing.T) {
+	brokers := newMemoryBrokers(t, 2)
+	events := make(received, 100)
+	subscribe(t, brokers[1], 1, events.handler)
+
+	for i := range 100 {
+		brokers[0].Publish(context.Background(), 1, []byte(fmt.Sprint(i)))
+	}
+	for i := range 100 {
+		events.expect(t, fmt.Sprint(i))
+	}
+}
+
+func TestBrokerSlowRoomDoesNotBlockOthers(t *testing.T) {
+	brokers := newMemoryBrokers(t, 2)
+
+	release := make(chan struct{})
+	defer close(release)
+	blocked := func(ctx context.Context, data []byte) { <-release }
+	events := make(received, 1)
+	subscribe(t, brokers[1], 1, blocked)
+	subscribe(t, brokers[1], 2, events.handler)
+
+	brokers[0].Publish(context.Background(), 1, []byte("stuck"))
+	brokers[0].Publish(context.Background(), 1, []byte("stuck"))
+	brokers[0].Publish(context.Background(), 2, []byte("hello"))
+	events.expect(t, "hello")
+}
+
+func TestConfirmations(t *testing.T) {
+	confirmed := newConfirmations()
+
+	err := confirmed.await(context.Background(), 1, func() error {
+		go confirmed.confirm(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("await: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = confirmed.await(ctx, 1, func() error {
+		confirmed.confirm(2)
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("await without confirmation returned %v", err)
+	}
+	if len(confirmed.waiting) != 0 {
+		t.Fatalf("%d rooms still waiting after the timeout", len(confirmed.waiting))
+	}
+}
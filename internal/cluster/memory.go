@@ -0,0 +1,100 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+
+	"chat-app/internal/metrics"
+)
+
+// MemoryNetwork connects brokers living in the same process. A single-instance
+// deployment uses one broker on its own network; tests can attach several to
+// stand in for multiple instances.
+type MemoryNetwork struct {
+	mutex   sync.RWMutex
+	brokers []*MemoryBroker
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{}
+}
+
+// Broker attaches a new broker for nodeID to the network.
+func (n *MemoryNetwork) Broker(nodeID string) *MemoryBroker {
+	b := &MemoryBroker{
+		network:  n,
+		subs:     newSubscriptions(nodeID),
+		incoming: make(chan event, publishQueue),
+	}
+
+	n.mutex.Lock()
+	n.brokers = append(n.brokers, b)
+	n.mutex.Unlock()
+	return b
+}
+
+// MemoryBroker is the in-process Broker and the default.
+type MemoryBroker struct {
+	network  *MemoryNetwork
+	subs     *subscriptions
+	incoming chan event
+}
+
+func NewMemoryBroker(nodeID string) *MemoryBroker {
+	return NewMemoryNetwork().Broker(nodeID)
+}
+
+func (b *MemoryBroker) NodeID() string {
+	return b.subs.nodeID
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, room int, data []byte) {
+	evt := newEvent(ctx, b.subs.nodeID, room, data)
+
+	b.network.mutex.RLock()
+	defer b.network.mutex.RUnlock()
+
+	for _, peer := range b.network.brokers {
+		if peer == b {
+			continue
+		}
+		select {
+		case peer.incoming <- evt:
+		default:
+			metrics.ClusterDropped.WithLabelValues("queue_full").Inc()
+		}
+	}
+	metrics.ClusterPublished.Inc()
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, room int, handler Handler) error {
+	b.subs.set(room, handler)
+	return nil
+}
+
+func (b *MemoryBroker) Unsubscribe(room int) {
+	b.subs.remove(room)
+}
+
+func (b *MemoryBroker) Run(ctx context.Context) {
+	for {
+		select {
+		case evt := <-b.incoming:
+			b.subs.dispatch(evt)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *MemoryBroker) Close() {
+	b.network.mutex.Lock()
+	defer b.network.mutex.Unlock()
+
+	for i, peer := range b.network.brokers {
+		if peer == b {
+			b.network.brokers = append(b.network.brokers[:i], b.network.brokers[i+1:]...)
+			break
+		}
+	}
+}
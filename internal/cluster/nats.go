@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"chat-app/internal/metrics"
+	"chat-app/pkg/logger"
+
+	"github.com/nats-io/nats.go"
+)
+
+const natsSubjectPrefix = "chat.room."
+
+// NATSBroker relays hub broadcasts over NATS, one subject per room.
+type NATSBroker struct {
+	conn *nats.Conn
+	subs *subscriptions
+
+	mutex   sync.Mutex
+	natsSub map[int]*nats.Subscription
+}
+
+func NewNATSBroker(url, nodeID string) (*NATSBroker, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("gochat "+nodeID),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Error("NATS disconnected: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			logger.Info("NATS reconnected to %s", conn.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	return &NATSBroker{
+		conn:    conn,
+		subs:    newSubscriptions(nodeID),
+		natsSub: make(map[int]*nats.Subscription),
+	}, nil
+}
+
+func (b *NATSBroker) NodeID() string {
+	return b.subs.nodeID
+}
+
+// Publish hands the event to the NATS client, which buffers and flushes it
+// asynchronously.
+func (b *NATSBroker) Publish(ctx context.Context, room int, data []byte) {
+	payload, err := json.Marshal(newEvent(ctx, b.subs.nodeID, room, data))
+	if err != nil {
+		metrics.ClusterDropped.WithLabelValues("invalid").Inc()
+		return
+	}
+
+	if err := b.conn.Publish(natsSubject(room), payload); err != nil {
+		metrics.ClusterDropped.WithLabelValues("publish_error").Inc()
+		logger.Error("Error publishing cluster event for room %d: %v", room, err)
+		return
+	}
+	metrics.ClusterPublished.Inc()
+}
+
+// Subscribe returns once the NATS server has processed the subscription.
+func (b *NATSBroker) Subscribe(ctx context.Context, room int, handler Handler) error {
+	b.subs.set(room, handler)
+
+	b.mutex.Lock()
+	if _, exists := b.natsSub[room]; !exists {
+		sub, err := b.conn.Subscribe(natsSubject(room), func(msg *nats.Msg) {
+			if evt, ok := decodeEvent(msg.Data); ok {
+				b.subs.dispatch(evt)
+			}
+		})
+		if err != nil {
+			b.mutex.Unlock()
+			return fmt.Errorf("failed to subscribe to room %d on NATS: %w", room, err)
+		}
+		b.natsSub[room] = sub
+	}
+	b.mutex.Unlock()
+
+	// The server handles a connection's protocol in order, so once a flush
+	// round trip completes the subscription is active
+	return b.conn.FlushWithContext(ctx)
+}
+
+func (b *NATSBroker) Unsubscribe(room int) {
+	b.subs.remove(room)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if sub, exists := b.natsSub[room]; exists {
+		if err := sub.Unsubscribe(); err != nil {
+			logger.Error("Error unsubscribing from room %d on NATS: %v", room, err)
+		}
+		delete(b.natsSub, room)
+	}
+}
+
+// Run waits for ctx; the NATS client delivers messages on its own goroutines.
+func (b *NATSBroker) Run(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (b *NATSBroker) Close() {
+	if err := b.conn.Drain(); err != nil {
+		b.conn.Close()
+	}
+}
+
+func natsSubject(room int) string {
+	return natsSubjectPrefix + strconv.Itoa(room)
+}
@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+)
+
+func newNATSBrokers(t *testing.T, n int) []*NATSBroker {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	srv := natsserver.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+
+	brokers := make([]*NATSBroker, n)
+	for i := range brokers {
+		b, err := NewNATSBroker(srv.ClientURL(), NewNodeID())
+		if err != nil {
+			t.Fatalf("connect broker: %v", err)
+		}
+		runBroker(t, b)
+		brokers[i] = b
+	}
+	return brokers
+}
+
+func TestNATSBrokerRelaysToOtherNodes(t *testing.T) {
+	brokers := newNATSBrokers(t, 3)
+	testRelay(t, brokers[0], brokers[1], brokers[2])
+}
+
+func TestNATSBrokerUnsubscribe(t *testing.T) {
+	brokers := newNATSBrokers(t, 2)
+	events := make(received, 1)
+	subscribe(t, brokers[1], 1, events.handler)
+
+	brokers[1].Unsubscribe(1)
+	brokers[0].Publish(t.Context(), 1, []byte("hello"))
+	events.expectNone(t)
+}
@@ -0,0 +1,246 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"chat-app/internal/metrics"
+	"chat-app/pkg/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// Postgres rejects NOTIFY payloads of 8000 bytes or more; larger events are
+	// stored in cluster_payloads and only their ID is sent.
+	maxNotifyPayload = 7900
+
+	channelPrefix = "chat_room_"
+	payloadTTL    = time.Minute
+)
+
+// PostgresBus relays hub broadcasts between server instances over Postgres
+// LISTEN/NOTIFY, one channel per room.
+type PostgresBus struct {
+	databaseURL string
+	pool        *pgxpool.Pool
+	subs        *subscriptions
+	confirmed   *confirmations
+	outgoing    chan event
+	wake        chan struct{}
+}
+
+func NewPostgresBus(ctx context.Context, databaseURL, nodeID string) (*PostgresBus, error) {
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect cluster bus: %w", err)
+	}
+
+	bus := &PostgresBus{
+		databaseURL: databaseURL,
+		pool:        pool,
+		subs:        newSubscriptions(nodeID),
+		confirmed:   newConfirmations(),
+		outgoing:    make(chan event, publishQueue),
+		wake:        make(chan struct{}, 1),
+	}
+	bus.subs.load = bus.loadPayload
+	return bus, nil
+}
+
+func (b *PostgresBus) NodeID() string {
+	return b.subs.nodeID
+}
+
+// Publish queues the event for the publisher goroutine; events are dropped if
+// the queue is full.
+func (b *PostgresBus) Publish(ctx context.Context, room int, data []byte) {
+	select {
+	case b.outgoing <- newEvent(ctx, b.subs.nodeID, room, data):
+	default:
+		metrics.ClusterDropped.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// Subscribe returns once the listener has run LISTEN for the room.
+func (b *PostgresBus) Subscribe(ctx context.Context, room int, handler Handler) error {
+	return b.confirmed.await(ctx, room, func() error {
+		b.subs.set(room, handler)
+		b.poke()
+		return nil
+	})
+}
+
+func (b *PostgresBus) Unsubscribe(room int) {
+	b.subs.remove(room)
+	b.poke()
+}
+
+// poke interrupts the listener so it can update its LISTEN set.
+func (b *PostgresBus) poke() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes queued events and listens for remote ones until ctx is done,
+// reconnecting the listener after failures.
+func (b *PostgresBus) Run(ctx context.Context) {
+	go b.publishLoop(ctx)
+	go b.pruneLoop(ctx)
+
+	for ctx.Err() == nil {
+		if err := b.listen(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("Cluster listener error, reconnecting: %v", err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+func (b *PostgresBus) Close() {
+	b.pool.Close()
+}
+
+func (b *PostgresBus) publishLoop(ctx context.Context) {
+	for {
+		select {
+		case evt := <-b.outgoing:
+			if err := b.publish(ctx, evt); err != nil {
+				metrics.ClusterDropped.WithLabelValues("publish_error").Inc()
+				logger.Error("Error publishing cluster event for room %d: %v", evt.Room, err)
+				continue
+			}
+			metrics.ClusterPublished.Inc()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *PostgresBus) publish(ctx context.Context, evt event) error {
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) >= maxNotifyPayload {
+		err := b.pool.QueryRow(ctx, `INSERT INTO cluster_payloads (payload) VALUES ($1) RETURNING id`, evt.Data).Scan(&evt.Ref)
+		if err != nil {
+			return fmt.Errorf("failed to store large payload: %w", err)
+		}
+		evt.Data = nil
+		if payload, err = json.Marshal(evt); err != nil {
+			return err
+		}
+	}
+
+	_, err = b.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, channelName(evt.Room), string(payload))
+	return err
+}
+
+// listen holds a dedicated connection, keeps its LISTEN set in line with the
+// subscribed rooms and dispatches notifications.
+func (b *PostgresBus) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, b.databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	listening := make(map[int]bool)
+	for {
+		if err := b.syncChannels(ctx, conn, listening); err != nil {
+			return err
+		}
+		for room := range listening {
+			b.confirmed.confirm(room)
+		}
+
+		// Wait for a notification, or until subscriptions change
+		waitCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-b.wake:
+				cancel()
+			case <-waitCtx.Done():
+			}
+		}()
+		notification, err := conn.WaitForNotification(waitCtx)
+		cancel()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if waitCtx.Err() != nil {
+				continue
+			}
+			return err
+		}
+
+		if evt, ok := decodeEvent([]byte(notification.Payload)); ok {
+			b.subs.dispatch(evt)
+		}
+	}
+}
+
+func (b *PostgresBus) syncChannels(ctx context.Context, conn *pgx.Conn, listening map[int]bool) error {
+	wanted := make(map[int]bool)
+	for _, room := range b.subs.rooms() {
+		wanted[room] = true
+	}
+
+	for room := range wanted {
+		if !listening[room] {
+			if _, err := conn.Exec(ctx, "LISTEN "+channelName(room)); err != nil {
+				return err
+			}
+			listening[room] = true
+		}
+	}
+	for room := range listening {
+		if !wanted[room] {
+			if _, err := conn.Exec(ctx, "UNLISTEN "+channelName(room)); err != nil {
+				return err
+			}
+			delete(listening, room)
+		}
+	}
+	return nil
+}
+
+// loadPayload fetches the data of an event too large to send with NOTIFY.
+func (b *PostgresBus) loadPayload(ctx context.Context, evt *event) error {
+	return b.pool.QueryRow(ctx, `SELECT payload FROM cluster_payloads WHERE id = $1`, evt.Ref).Scan(&evt.Data)
+}
+
+// pruneLoop removes stored payloads once every instance has had time to read
+// them.
+func (b *PostgresBus) pruneLoop(ctx context.Context) {
+	ticker := time.NewTicker(payloadTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, err := b.pool.Exec(ctx, `DELETE FROM cluster_payloads WHERE created_at < $1`, time.Now().Add(-payloadTTL))
+			if err != nil && ctx.Err() == nil {
+				logger.Error("Error pruning cluster payloads: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func channelName(room int) string {
+	return channelPrefix + strconv.Itoa(room)
+}
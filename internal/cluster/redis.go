@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"chat-app/internal/metrics"
+	"chat-app/pkg/logger"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisChannelPrefix = "chat:room:"
+
+// RedisBroker relays hub broadcasts over Redis pub/sub, one channel per room.
+type RedisBroker struct {
+	client    *redis.Client
+	pubsub    *redis.PubSub
+	subs      *subscriptions
+	confirmed *confirmations
+	outgoing  chan event
+}
+
+func NewRedisBroker(ctx context.Context, url, nodeID string) (*RedisBroker, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &RedisBroker{
+		client:    client,
+		pubsub:    client.Subscribe(ctx),
+		subs:      newSubscriptions(nodeID),
+		confirmed: newConfirmations(),
+		outgoing:  make(chan event, publishQueue),
+	}, nil
+}
+
+func (b *RedisBroker) NodeID() string {
+	return b.subs.nodeID
+}
+
+// Publish queues the event for the publisher goroutine; events are dropped if
+// the queue is full.
+func (b *RedisBroker) Publish(ctx context.Context, room int, data []byte) {
+	select {
+	case b.outgoing <- newEvent(ctx, b.subs.nodeID, room, data):
+	default:
+		metrics.ClusterDropped.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// Subscribe returns once Redis has confirmed the subscription, which Run
+// receives.
+func (b *RedisBroker) Subscribe(ctx context.Context, room int, handler Handler) error {
+	b.subs.set(room, handler)
+	return b.confirmed.await(ctx, room, func() error {
+		if err := b.pubsub.Subscribe(ctx, redisChannel(room)); err != nil {
+			return fmt.Errorf("failed to subscribe to room %d on Redis: %w", room, err)
+		}
+		return nil
+	})
+}
+
+func (b *RedisBroker) Unsubscribe(room int) {
+	b.subs.remove(room)
+	if err := b.pubsub.Unsubscribe(context.Background(), redisChannel(room)); err != nil {
+		logger.Error("Error unsubscribing from room %d on Redis: %v", room, err)
+	}
+}
+
+// Run publishes queued events and delivers remote ones until ctx is done. The
+// Redis client resubscribes by itself after reconnecting.
+func (b *RedisBroker) Run(ctx context.Context) {
+	go b.publishLoop(ctx)
+
+	messages := b.pubsub.ChannelWithSubscriptions()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			switch msg := msg.(type) {
+			case *redis.Subscription:
+				if room, ok := redisRoom(msg.Channel); ok && msg.Kind == "subscribe" {
+					b.confirmed.confirm(room)
+				}
+			case *redis.Message:
+				if evt, ok := decodeEvent([]byte(msg.Payload)); ok {
+					b.subs.dispatch(evt)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *RedisBroker) publishLoop(ctx context.Context) {
+	for {
+		select {
+		case evt := <-b.outgoing:
+			payload, err := json.Marshal(evt)
+			if err != nil {
+				metrics.ClusterDropped.WithLabelValues("invalid").Inc()
+				continue
+			}
+			if err := b.client.Publish(ctx, redisChannel(evt.Room), payload).Err(); err != nil {
+				metrics.ClusterDropped.WithLabelValues("publish_error").Inc()
+				logger.Error("Error publishing cluster event for room %d: %v", evt.Room, err)
+				continue
+			}
+			metrics.ClusterPublished.Inc()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *RedisBroker) Close() {
+	b.pubsub.Close()
+	b.client.Close()
+}
+
+func redisChannel(room int) string {
+	return redisChannelPrefix + strconv.Itoa(room)
+}
+
+func redisRoom(channel string) (int, bool) {
+	room, err := strconv.Atoi(strings.TrimPrefix(channel, redisChannelPrefix))
+	return room, err == nil
+}
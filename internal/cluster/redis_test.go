@@ -0,0 +1,37 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newRedisBrokers(t *testing.T, n int) []*RedisBroker {
+	srv := miniredis.RunT(t)
+
+	brokers := make([]*RedisBroker, n)
+	for i := range brokers {
+		b, err := NewRedisBroker(t.Context(), "redis://"+srv.Addr(), NewNodeID())
+		if err != nil {
+			t.Fatalf("connect broker: %v", err)
+		}
+		runBroker(t, b)
+		brokers[i] = b
+	}
+	return brokers
+}
+
+func TestRedisBrokerRelaysToOtherNodes(t *testing.T) {
+	brokers := newRedisBrokers(t, 3)
+	testRelay(t, brokers[0], brokers[1], brokers[2])
+}
+
+func TestRedisBrokerUnsubscribe(t *testing.T) {
+	brokers := newRedisBrokers(t, 2)
+	events := make(received, 1)
+	subscribe(t, brokers[1], 1, events.handler)
+
+	brokers[1].Unsubscribe(1)
+	brokers[0].Publish(t.Context(), 1, []byte("hello"))
+	events.expectNone(t)
+}
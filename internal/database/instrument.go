@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+
+	"chat-app/internal/metrics"
+	"chat-app/internal/tracing"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrument times a repository call and wraps it in a span; the statements
+// it runs become child spans via queryTracer.
+func instrument(ctx context.Context, repository, operation string) (context.Context, func()) {
+	observe := metrics.ObserveQuery(repository, operation)
+	ctx, span := tracing.Tracer().Start(ctx, "db."+repository+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "postgresql")),
+	)
+
+	return ctx, func() {
+		span.End()
+		observe()
+	}
+}
+
+// queryTracer records a span for every SQL statement executed through pgx.
+type queryTracer struct{}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracing.Tracer().Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", data.SQL),
+		),
+	)
+	return ctx
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	} else {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	span.End()
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"chat-app/internal/models"
 )
@@ -12,23 +13,55 @@ type UserRepository interface {
 	GetUserByID(ctx context.Context, id int) (*models.User, error)
 }
 
+type AdminRepository interface {
+	ListUsers(ctx context.Context, search string, limit, offset int) ([]*models.User, error)
+	SetUserActive(ctx context.Context, userID int, active bool) error
+	RequirePasswordReset(ctx context.Context, userID int) error
+	UpdatePassword(ctx context.Context, userID int, password string) error
+	SetUserAdmin(ctx context.Context, userID int, isAdmin bool) error
+	GetServerStats(ctx context.Context) (*models.ServerStats, error)
+}
+
+type MFARepository interface {
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID int) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
+	UseTOTPCounter(ctx context.Context, userID int, counter int64) (bool, error)
+}
+
+type UserSessionRepository interface {
+	CreateUserSession(ctx context.Context, session *models.UserSession) error
+	GetUserSession(ctx context.Context, sessionID string) (*models.UserSession, error)
+	TouchUserSession(ctx context.Context, sessionID string) error
+	ListUserSessions(ctx context.Context, userID int) ([]*models.UserSession, error)
+	RevokeUserSession(ctx context.Context, userID int, sessionID string) error
+	RevokeAllUserSessions(ctx context.Context, userID int) error
+}
+
 type RoomRepository interface {
 	GetOrCreateRoom(ctx context.Context, name string) (int, error)
 	CreateRoom(ctx context.Context, req *models.CreateRoomRequest, ownerID int) (*models.Room, error)
 	GetRoomByID(ctx context.Context, id int) (*models.Room, error)
 	ListUserRooms(ctx context.Context, userID int) ([]*models.Room, error)
 	DeleteRoom(ctx context.Context, roomID, ownerID int) error
+	ForceDeleteRoom(ctx context.Context, roomID int) error
 }
 
 type MessageRepository interface {
-	SaveMessage(ctx context.Context, userID, roomID int, content string) error
+	SaveMessage(ctx context.Context, userID, roomID int, content, clientID string) (msg *models.Message, created bool, err error)
+	EditMessage(ctx context.Context, messageID, userID, roomID int, content string) (*models.Message, error)
+	SetReaction(ctx context.Context, messageID, userID, roomID int, emoji string, add bool) error
 	LoadRecentMessages(ctx context.Context, roomID, limit int) ([]*models.Message, error)
+	LoadMessagesAfter(ctx context.Context, roomID int, afterSeq int64, limit int) ([]*models.Message, error)
 }
 
 type SessionRepository interface {
 	CreateActiveSession(ctx context.Context, userID, roomID int, sessionID string) error
 	RemoveActiveSession(ctx context.Context, userID, roomID int, sessionID string) error
-	UpdateSessionActivity(ctx context.Context, userID, roomID int, sessionID string) error
+	RemoveActiveSessions(ctx context.Context, sessionIDs []string) error
+	TouchActiveSessions(ctx context.Context, sessionIDs []string) error
+	ReapActiveSessions(ctx context.Context) (int64, error)
 	GetActiveUsersInRoom(ctx context.Context, roomID int) ([]*models.ActiveUser, error)
 }
 
@@ -39,11 +72,37 @@ type MembershipRepository interface {
 	GetRoomMembers(ctx context.Context, roomID int) ([]*models.Member, error)
 }
 
+type RateLimitRepository interface {
+	AddAttempt(ctx context.Context, key string, at time.Time) error
+	CountAttempts(ctx context.Context, key string, since time.Time) (int, time.Time, error)
+	ClearAttempts(ctx context.Context, key string) error
+	SetLockout(ctx context.Context, key string, until time.Time) error
+	GetLockout(ctx context.Context, key string) (time.Time, error)
+	PruneAttempts(ctx context.Context, before time.Time) error
+}
+
+type AuditRepository interface {
+	CreateAuditEvent(ctx context.Context, event *models.AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error)
+}
+
+type HealthRepository interface {
+	Ping(ctx context.Context) error
+	CheckSchema(ctx context.Context) error
+	PoolStats() models.PoolStats
+}
+
 type Database interface {
 	UserRepository
+	AdminRepository
+	MFARepository
+	UserSessionRepository
 	RoomRepository
 	MessageRepository
 	SessionRepository
 	MembershipRepository
+	RateLimitRepository
+	AuditRepository
+	HealthRepository
 	Close() error
 }
\ No newline at end of file
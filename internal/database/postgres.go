@@ -2,21 +2,33 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"chat-app/internal/models"
 	"chat-app/pkg/logger"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type PostgresDB struct {
-	pool *pgxpool.Pool
+	pool             *pgxpool.Pool
+	activeSessionTTL atomic.Int64
 }
 
 func NewPostgresDB(databaseURL string) (*PostgresDB, error) {
-	pool, err := pgxpool.New(context.Background(), databaseURL)
+	poolConfig, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database URL: %w", err)
+	}
+	poolConfig.ConnConfig.Tracer = &queryTracer{}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -27,7 +39,19 @@ func NewPostgresDB(databaseURL string) (*PostgresDB, error) {
 	}
 
 	logger.Info("Connected to database successfully")
-	return &PostgresDB{pool: pool}, nil
+	db := &PostgresDB{pool: pool}
+	db.SetActiveSessionTTL(5 * time.Minute)
+	return db, nil
+}
+
+// SetActiveSessionTTL sets how long room presence rows live without activity.
+func (db *PostgresDB) SetActiveSessionTTL(ttl time.Duration) {
+	db.activeSessionTTL.Store(int64(ttl))
+}
+
+// Stat returns connection pool statistics.
+func (db *PostgresDB) Stat() *pgxpool.Stat {
+	return db.pool.Stat()
 }
 
 func (db *PostgresDB) Close() error {
@@ -37,11 +61,17 @@ func (db *PostgresDB) Close() error {
 
 // User Repository Implementation
 func (db *PostgresDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	query := `SELECT id, username, email, password_hash, created_at FROM users WHERE email = $1`
+	ctx, done := instrument(ctx, "user", "GetUserByEmail")
+	defer done()
+	query := `
+		SELECT id, username, email, password_hash, COALESCE(totp_secret, ''), totp_enabled,
+			is_admin, is_active, must_reset_password, created_at
+		FROM users WHERE email = $1`
 	
 	user := &models.User{}
 	err := db.pool.QueryRow(ctx, query, email).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt,
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.TOTPSecret, &user.TOTPEnabled,
+		&user.IsAdmin, &user.IsActive, &user.MustReset, &user.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -51,6 +81,8 @@ func (db *PostgresDB) GetUserByEmail(ctx context.Context, email string) (*models
 }
 
 func (db *PostgresDB) CreateUser(ctx context.Context, req *models.RegisterRequest) (*models.User, error) {
+	ctx, done := instrument(ctx, "user", "CreateUser")
+	defer done()
 	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %w", err)
@@ -61,7 +93,7 @@ func (db *PostgresDB) CreateUser(ctx context.Context, req *models.RegisterReques
 		VALUES ($1, $2, $3, NOW()) 
 		RETURNING id, username, email, created_at`
 	
-	user := &models.User{PasswordHash: string(hash)}
+	user := &models.User{PasswordHash: string(hash), IsActive: true}
 	err = db.pool.QueryRow(ctx, query, req.Username, req.Email, string(hash)).Scan(
 		&user.ID, &user.Username, &user.Email, &user.CreatedAt,
 	)
@@ -73,11 +105,17 @@ func (db *PostgresDB) CreateUser(ctx context.Context, req *models.RegisterReques
 }
 
 func (db *PostgresDB) GetUserByID(ctx context.Context, id int) (*models.User, error) {
-	query := `SELECT id, username, email, created_at FROM users WHERE id = $1`
+	ctx, done := instrument(ctx, "user", "GetUserByID")
+	defer done()
+	query := `
+		SELECT id, username, email, password_hash, COALESCE(totp_secret, ''), totp_enabled,
+			is_admin, is_active, must_reset_password, created_at
+		FROM users WHERE id = $1`
 	
 	user := &models.User{}
 	err := db.pool.QueryRow(ctx, query, id).Scan(
-		&user.ID, &user.Username, &user.Email, &user.CreatedAt,
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.TOTPSecret, &user.TOTPEnabled,
+		&user.IsAdmin, &user.IsActive, &user.MustReset, &user.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -86,8 +124,311 @@ func (db *PostgresDB) GetUserByID(ctx context.Context, id int) (*models.User, er
 	return user, nil
 }
 
+// Admin Repository Implementation
+func (db *PostgresDB) ListUsers(ctx context.Context, search string, limit, offset int) ([]*models.User, error) {
+	ctx, done := instrument(ctx, "admin", "ListUsers")
+	defer done()
+	query := `
+		SELECT id, username, email, totp_enabled, is_admin, is_active, must_reset_password, created_at
+		FROM users
+		WHERE $1 = '' OR username ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%'
+		ORDER BY id
+		LIMIT $2 OFFSET $3`
+	
+	rows, err := db.pool.Query(ctx, query, search, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user := &models.User{}
+		if err := rows.Scan(
+			&user.ID, &user.Username, &user.Email, &user.TOTPEnabled,
+			&user.IsAdmin, &user.IsActive, &user.MustReset, &user.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	
+	return users, nil
+}
+
+func (db *PostgresDB) SetUserActive(ctx context.Context, userID int, active bool) error {
+	ctx, done := instrument(ctx, "admin", "SetUserActive")
+	defer done()
+	tag, err := db.pool.Exec(ctx, "UPDATE users SET is_active = $2 WHERE id = $1", userID, active)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (db *PostgresDB) SetUserAdmin(ctx context.Context, userID int, isAdmin bool) error {
+	ctx, done := instrument(ctx, "admin", "SetUserAdmin")
+	defer done()
+	tag, err := db.pool.Exec(ctx, "UPDATE users SET is_admin = $2 WHERE id = $1", userID, isAdmin)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (db *PostgresDB) RequirePasswordReset(ctx context.Context, userID int) error {
+	ctx, done := instrument(ctx, "admin", "RequirePasswordReset")
+	defer done()
+	tag, err := db.pool.Exec(ctx, "UPDATE users SET must_reset_password = true WHERE id = $1", userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (db *PostgresDB) UpdatePassword(ctx context.Context, userID int, password string) error {
+	ctx, done := instrument(ctx, "admin", "UpdatePassword")
+	defer done()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	query := `UPDATE users SET password_hash = $2, must_reset_password = false WHERE id = $1`
+	_, err = db.pool.Exec(ctx, query, userID, string(hash))
+	return err
+}
+
+func (db *PostgresDB) GetServerStats(ctx context.Context) (*models.ServerStats, error) {
+	ctx, done := instrument(ctx, "admin", "GetServerStats")
+	defer done()
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users WHERE is_active = false),
+			(SELECT COUNT(*) FROM users WHERE is_admin = true),
+			(SELECT COUNT(*) FROM rooms),
+			(SELECT COUNT(*) FROM rooms WHERE is_public = true),
+			(SELECT COUNT(*) FROM messages),
+			(SELECT COUNT(*) FROM active_sessions)`
+	
+	stats := &models.ServerStats{}
+	err := db.pool.QueryRow(ctx, query).Scan(
+		&stats.Users, &stats.DeactivatedUsers, &stats.Admins,
+		&stats.Rooms, &stats.PublicRooms, &stats.Messages, &stats.ActiveSessions,
+	)
+	if err != nil {
+		return nil, err
+	}
+	
+	return stats, nil
+}
+
+// MFA Repository Implementation
+func (db *PostgresDB) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	ctx, done := instrument(ctx, "mfa", "SetTOTPSecret")
+	defer done()
+	query := `UPDATE users SET totp_secret = $2 WHERE id = $1 AND totp_enabled = false`
+	tag, err := db.pool.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("two-factor authentication already enabled")
+	}
+	return nil
+}
+
+func (db *PostgresDB) EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	ctx, done := instrument(ctx, "mfa", "EnableTOTP")
+	defer done()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "UPDATE users SET totp_enabled = true WHERE id = $1", userID); err != nil {
+		return err
+	}
+
+	// Replace any previous recovery codes
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (db *PostgresDB) DisableTOTP(ctx context.Context, userID int) error {
+	ctx, done := instrument(ctx, "mfa", "DisableTOTP")
+	defer done()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "UPDATE users SET totp_enabled = false, totp_secret = NULL WHERE id = $1", userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (db *PostgresDB) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	ctx, done := instrument(ctx, "mfa", "UseRecoveryCode")
+	defer done()
+	query := `
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	
+	tag, err := db.pool.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// UseTOTPCounter records the time step of an accepted TOTP code, reporting
+// false if a code for the same or a later step was already accepted.
+func (db *PostgresDB) UseTOTPCounter(ctx context.Context, userID int, counter int64) (bool, error) {
+	ctx, done := instrument(ctx, "mfa", "UseTOTPCounter")
+	defer done()
+	query := `
+		UPDATE users SET totp_last_counter = $2
+		WHERE id = $1 AND (totp_last_counter IS NULL OR totp_last_counter < $2)`
+
+	tag, err := db.pool.Exec(ctx, query, userID, counter)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// User Session Repository Implementation
+func (db *PostgresDB) CreateUserSession(ctx context.Context, session *models.UserSession) error {
+	ctx, done := instrument(ctx, "user_session", "CreateUserSession")
+	defer done()
+	query := `
+		INSERT INTO user_sessions (id, user_id, device_name, user_agent, ip, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), NOW(), $6)
+		RETURNING created_at, last_used_at`
+	
+	return db.pool.QueryRow(ctx, query,
+		session.ID, session.UserID, session.DeviceName, session.UserAgent, session.IP, session.ExpiresAt,
+	).Scan(&session.CreatedAt, &session.LastUsedAt)
+}
+
+func (db *PostgresDB) GetUserSession(ctx context.Context, sessionID string) (*models.UserSession, error) {
+	ctx, done := instrument(ctx, "user_session", "GetUserSession")
+	defer done()
+	query := `
+		SELECT id, user_id, device_name, user_agent, ip, created_at, last_used_at, expires_at
+		FROM user_sessions
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()`
+	
+	session := &models.UserSession{}
+	err := db.pool.QueryRow(ctx, query, sessionID).Scan(
+		&session.ID, &session.UserID, &session.DeviceName, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	
+	return session, nil
+}
+
+func (db *PostgresDB) TouchUserSession(ctx context.Context, sessionID string) error {
+	ctx, done := instrument(ctx, "user_session", "TouchUserSession")
+	defer done()
+	// Only write when the timestamp is stale to avoid an UPDATE per request
+	query := `
+		UPDATE user_sessions SET last_used_at = NOW()
+		WHERE id = $1 AND last_used_at < NOW() - INTERVAL '1 minute'`
+	_, err := db.pool.Exec(ctx, query, sessionID)
+	return err
+}
+
+func (db *PostgresDB) ListUserSessions(ctx context.Context, userID int) ([]*models.UserSession, error) {
+	ctx, done := instrument(ctx, "user_session", "ListUserSessions")
+	defer done()
+	query := `
+		SELECT id, user_id, device_name, user_agent, ip, created_at, last_used_at, expires_at
+		FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`
+	
+	rows, err := db.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.UserSession
+	for rows.Next() {
+		session := &models.UserSession{}
+		if err := rows.Scan(
+			&session.ID, &session.UserID, &session.DeviceName, &session.UserAgent, &session.IP,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	
+	return sessions, nil
+}
+
+func (db *PostgresDB) RevokeUserSession(ctx context.Context, userID int, sessionID string) error {
+	ctx, done := instrument(ctx, "user_session", "RevokeUserSession")
+	defer done()
+	query := `
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	
+	tag, err := db.pool.Exec(ctx, query, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("session not found")
+	}
+	return nil
+}
+
+func (db *PostgresDB) RevokeAllUserSessions(ctx context.Context, userID int) error {
+	ctx, done := instrument(ctx, "user_session", "RevokeAllUserSessions")
+	defer done()
+	query := `UPDATE user_sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := db.pool.Exec(ctx, query, userID)
+	return err
+}
+
 // Room Repository Implementation
 func (db *PostgresDB) GetOrCreateRoom(ctx context.Context, name string) (int, error) {
+	ctx, done := instrument(ctx, "room", "GetOrCreateRoom")
+	defer done()
 	query := `
 		INSERT INTO rooms (name, is_public, created_at) VALUES ($1, true, NOW())
 		ON CONFLICT (name) DO UPDATE SET name=EXCLUDED.name
@@ -99,15 +440,17 @@ func (db *PostgresDB) GetOrCreateRoom(ctx context.Context, name string) (int, er
 }
 
 func (db *PostgresDB) CreateRoom(ctx context.Context, req *models.CreateRoomRequest, ownerID int) (*models.Room, error) {
+	ctx, done := instrument(ctx, "room", "CreateRoom")
+	defer done()
 	query := `
-		INSERT INTO rooms (name, is_public, owner_id, created_at) 
-		VALUES ($1, $2, $3, NOW())
+		INSERT INTO rooms (name, is_public, owner_id, message_rate, message_burst, slow_consumer_policy, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
 		ON CONFLICT (name) DO UPDATE SET is_public = EXCLUDED.is_public
-		RETURNING id, name, is_public, owner_id, created_at`
+		RETURNING id, name, is_public, owner_id, message_rate, message_burst, slow_consumer_policy, created_at`
 	
 	room := &models.Room{}
-	err := db.pool.QueryRow(ctx, query, req.Name, req.IsPublic, ownerID).Scan(
-		&room.ID, &room.Name, &room.IsPublic, &room.OwnerID, &room.CreatedAt,
+	err := db.pool.QueryRow(ctx, query, req.Name, req.IsPublic, ownerID, req.MessageRate, req.MessageBurst, req.SlowConsumerPolicy).Scan(
+		&room.ID, &room.Name, &room.IsPublic, &room.OwnerID, &room.MessageRate, &room.MessageBurst, &room.SlowConsumerPolicy, &room.CreatedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create room: %w", err)
@@ -117,11 +460,15 @@ func (db *PostgresDB) CreateRoom(ctx context.Context, req *models.CreateRoomRequ
 }
 
 func (db *PostgresDB) GetRoomByID(ctx context.Context, id int) (*models.Room, error) {
-	query := `SELECT id, name, is_public, owner_id, created_at FROM rooms WHERE id = $1`
+	ctx, done := instrument(ctx, "room", "GetRoomByID")
+	defer done()
+	query := `
+		SELECT id, name, is_public, owner_id, message_rate, message_burst, slow_consumer_policy, created_at
+		FROM rooms WHERE id = $1`
 	
 	room := &models.Room{}
 	err := db.pool.QueryRow(ctx, query, id).Scan(
-		&room.ID, &room.Name, &room.IsPublic, &room.OwnerID, &room.CreatedAt,
+		&room.ID, &room.Name, &room.IsPublic, &room.OwnerID, &room.MessageRate, &room.MessageBurst, &room.SlowConsumerPolicy, &room.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -131,8 +478,10 @@ func (db *PostgresDB) GetRoomByID(ctx context.Context, id int) (*models.Room, er
 }
 
 func (db *PostgresDB) ListUserRooms(ctx context.Context, userID int) ([]*models.Room, error) {
+	ctx, done := instrument(ctx, "room", "ListUserRooms")
+	defer done()
 	query := `
-		SELECT r.id, r.name, r.is_public, r.owner_id, r.created_at
+		SELECT r.id, r.name, r.is_public, r.owner_id, r.message_rate, r.message_burst, r.slow_consumer_policy, r.created_at
 		FROM rooms r
 		LEFT JOIN memberships m ON r.id = m.room_id AND m.user_id = $1
 		WHERE r.is_public = true OR m.user_id IS NOT NULL
@@ -147,7 +496,7 @@ func (db *PostgresDB) ListUserRooms(ctx context.Context, userID int) ([]*models.
 	var rooms []*models.Room
 	for rows.Next() {
 		room := &models.Room{}
-		if err := rows.Scan(&room.ID, &room.Name, &room.IsPublic, &room.OwnerID, &room.CreatedAt); err != nil {
+		if err := rows.Scan(&room.ID, &room.Name, &room.IsPublic, &room.OwnerID, &room.MessageRate, &room.MessageBurst, &room.SlowConsumerPolicy, &room.CreatedAt); err != nil {
 			return nil, err
 		}
 		rooms = append(rooms, room)
@@ -157,6 +506,8 @@ func (db *PostgresDB) ListUserRooms(ctx context.Context, userID int) ([]*models.
 }
 
 func (db *PostgresDB) DeleteRoom(ctx context.Context, roomID, ownerID int) error {
+	ctx, done := instrument(ctx, "room", "DeleteRoom")
+	defer done()
 	// Check ownership first
 	var currentOwnerID int
 	err := db.pool.QueryRow(ctx, "SELECT owner_id FROM rooms WHERE id = $1", roomID).Scan(&currentOwnerID)
@@ -168,6 +519,12 @@ func (db *PostgresDB) DeleteRoom(ctx context.Context, roomID, ownerID int) error
 		return fmt.Errorf("forbidden - not the room owner")
 	}
 
+	return db.ForceDeleteRoom(ctx, roomID)
+}
+
+func (db *PostgresDB) ForceDeleteRoom(ctx context.Context, roomID int) error {
+	ctx, done := instrument(ctx, "room", "ForceDeleteRoom")
+	defer done()
 	// Delete in transaction
 	tx, err := db.pool.Begin(ctx)
 	if err != nil {
@@ -191,23 +548,119 @@ func (db *PostgresDB) DeleteRoom(ctx context.Context, roomID, ownerID int) error
 	}
 	
 	// Delete room
-	if _, err := tx.Exec(ctx, "DELETE FROM rooms WHERE id = $1", roomID); err != nil {
+	tag, err := tx.Exec(ctx, "DELETE FROM rooms WHERE id = $1", roomID)
+	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("room not found")
+	}
 
 	return tx.Commit(ctx)
 }
 
 // Message Repository Implementation
-func (db *PostgresDB) SaveMessage(ctx context.Context, userID, roomID int, content string) error {
-	query := `INSERT INTO messages (user_id, room_id, content, created_at) VALUES ($1, $2, $3, NOW())`
-	_, err := db.pool.Exec(ctx, query, userID, roomID, content)
+
+// ErrMessageNotFound is returned for messages that don't exist in the room or,
+// for edits, weren't posted by the user.
+var ErrMessageNotFound = errors.New("message not found")
+
+// SaveMessage stores a message under the room's next sequence number. A
+// message the user already sent with the same non-empty clientID is not
+// stored again; the original is returned instead with created set to false.
+func (db *PostgresDB) SaveMessage(ctx context.Context, userID, roomID int, content, clientID string) (*models.Message, bool, error) {
+	ctx, done := instrument(ctx, "message", "SaveMessage")
+	defer done()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The room row stays locked until commit, so a room's messages get
+	// consecutive numbers and commit in order
+	msg := &models.Message{UserID: userID, RoomID: roomID, Content: content, ClientID: clientID}
+	if err := tx.QueryRow(ctx, `UPDATE rooms SET last_seq = last_seq + 1 WHERE id = $1 RETURNING last_seq`, roomID).Scan(&msg.Seq); err != nil {
+		return nil, false, err
+	}
+
+	// A resend returns the original; rolling back gives the number back
+	if clientID != "" {
+		query := `SELECT id, content, seq, created_at FROM messages WHERE user_id = $1 AND room_id = $2 AND client_id = $3`
+		err := tx.QueryRow(ctx, query, userID, roomID, clientID).Scan(&msg.ID, &msg.Content, &msg.Seq, &msg.CreatedAt)
+		if err == nil {
+			return msg, false, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, err
+		}
+	}
+
+	query := `
+		INSERT INTO messages (user_id, room_id, content, client_id, seq, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NOW())
+		RETURNING id, created_at`
+	if err := tx.QueryRow(ctx, query, userID, roomID, content, clientID, msg.Seq).Scan(&msg.ID, &msg.CreatedAt); err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return msg, true, nil
+}
+
+// EditMessage replaces the content of a message the user posted in the room.
+func (db *PostgresDB) EditMessage(ctx context.Context, messageID, userID, roomID int, content string) (*models.Message, error) {
+	ctx, done := instrument(ctx, "message", "EditMessage")
+	defer done()
+	query := `
+		UPDATE messages SET content = $4, edited_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND room_id = $3
+		RETURNING created_at, edited_at`
+
+	msg := &models.Message{ID: messageID, UserID: userID, RoomID: roomID, Content: content}
+	err := db.pool.QueryRow(ctx, query, messageID, userID, roomID, content).Scan(&msg.CreatedAt, &msg.EditedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// SetReaction adds or removes the user's emoji reaction on a message in the
+// room. Adding a reaction twice or removing a missing one is not an error.
+func (db *PostgresDB) SetReaction(ctx context.Context, messageID, userID, roomID int, emoji string, add bool) error {
+	ctx, done := instrument(ctx, "message", "SetReaction")
+	defer done()
+
+	var exists bool
+	err := db.pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1 AND room_id = $2)`, messageID, roomID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrMessageNotFound
+	}
+
+	if add {
+		_, err = db.pool.Exec(ctx, `
+			INSERT INTO message_reactions (message_id, user_id, emoji)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING`, messageID, userID, emoji)
+	} else {
+		_, err = db.pool.Exec(ctx, `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`, messageID, userID, emoji)
+	}
 	return err
 }
 
 func (db *PostgresDB) LoadRecentMessages(ctx context.Context, roomID, limit int) ([]*models.Message, error) {
+	ctx, done := instrument(ctx, "message", "LoadRecentMessages")
+	defer done()
 	query := `
-		SELECT m.id, m.user_id, m.room_id, m.content, u.username, m.created_at
+		SELECT m.id, m.user_id, m.room_id, m.content, COALESCE(m.client_id, ''), m.seq, u.username, m.created_at
 		FROM messages m 
 		JOIN users u ON m.user_id = u.id
 		WHERE m.room_id = $1 
@@ -223,7 +676,7 @@ func (db *PostgresDB) LoadRecentMessages(ctx context.Context, roomID, limit int)
 	var messages []*models.Message
 	for rows.Next() {
 		msg := &models.Message{}
-		if err := rows.Scan(&msg.ID, &msg.UserID, &msg.RoomID, &msg.Content, &msg.Username, &msg.CreatedAt); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.UserID, &msg.RoomID, &msg.Content, &msg.ClientID, &msg.Seq, &msg.Username, &msg.CreatedAt); err != nil {
 			return nil, err
 		}
 		messages = append(messages, msg)
@@ -237,8 +690,40 @@ func (db *PostgresDB) LoadRecentMessages(ctx context.Context, roomID, limit int)
 	return messages, nil
 }
 
+// LoadMessagesAfter returns up to limit of the room's messages numbered after
+// afterSeq, oldest first.
+func (db *PostgresDB) LoadMessagesAfter(ctx context.Context, roomID int, afterSeq int64, limit int) ([]*models.Message, error) {
+	ctx, done := instrument(ctx, "message", "LoadMessagesAfter")
+	defer done()
+	query := `
+		SELECT m.id, m.user_id, m.room_id, m.content, COALESCE(m.client_id, ''), m.seq, u.username, m.created_at
+		FROM messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = $1 AND m.seq > $2
+		ORDER BY m.seq
+		LIMIT $3`
+
+	rows, err := db.pool.Query(ctx, query, roomID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.Message
+	for rows.Next() {
+		msg := &models.Message{}
+		if err := rows.Scan(&msg.ID, &msg.UserID, &msg.RoomID, &msg.Content, &msg.ClientID, &msg.Seq, &msg.Username, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
 // Session Repository Implementation
 func (db *PostgresDB) CreateActiveSession(ctx context.Context, userID, roomID int, sessionID string) error {
+	ctx, done := instrument(ctx, "session", "CreateActiveSession")
+	defer done()
 	query := `
 		INSERT INTO active_sessions (user_id, room_id, session_id, connected_at, last_seen) 
 		VALUES ($1, $2, $3, NOW(), NOW())
@@ -250,29 +735,54 @@ func (db *PostgresDB) CreateActiveSession(ctx context.Context, userID, roomID in
 }
 
 func (db *PostgresDB) RemoveActiveSession(ctx context.Context, userID, roomID int, sessionID string) error {
+	ctx, done := instrument(ctx, "session", "RemoveActiveSession")
+	defer done()
 	query := `DELETE FROM active_sessions WHERE user_id = $1 AND room_id = $2 AND session_id = $3`
 	_, err := db.pool.Exec(ctx, query, userID, roomID, sessionID)
 	return err
 }
 
-func (db *PostgresDB) UpdateSessionActivity(ctx context.Context, userID, roomID int, sessionID string) error {
-	query := `UPDATE active_sessions SET last_seen = NOW() WHERE user_id = $1 AND room_id = $2 AND session_id = $3`
-	_, err := db.pool.Exec(ctx, query, userID, roomID, sessionID)
+// RemoveActiveSessions deletes the sessions in a single statement.
+func (db *PostgresDB) RemoveActiveSessions(ctx context.Context, sessionIDs []string) error {
+	ctx, done := instrument(ctx, "session", "RemoveActiveSessions")
+	defer done()
+	query := `DELETE FROM active_sessions WHERE session_id = ANY($1)`
+	_, err := db.pool.Exec(ctx, query, sessionIDs)
 	return err
 }
 
-func (db *PostgresDB) GetActiveUsersInRoom(ctx context.Context, roomID int) ([]*models.ActiveUser, error) {
-	// Clean up stale sessions
-	cleanupQuery := `DELETE FROM active_sessions WHERE last_seen < NOW() - INTERVAL '5 minutes'`
-	if _, err := db.pool.Exec(ctx, cleanupQuery); err != nil {
-		logger.Error("Error cleaning stale sessions: %v", err)
+// TouchActiveSessions marks the sessions as seen now in a single statement.
+func (db *PostgresDB) TouchActiveSessions(ctx context.Context, sessionIDs []string) error {
+	ctx, done := instrument(ctx, "session", "TouchActiveSessions")
+	defer done()
+	query := `UPDATE active_sessions SET last_seen = NOW() WHERE session_id = ANY($1)`
+	_, err := db.pool.Exec(ctx, query, sessionIDs)
+	return err
+}
+
+// ReapActiveSessions deletes sessions whose heartbeats stopped, such as
+// those left behind by a crashed instance.
+func (db *PostgresDB) ReapActiveSessions(ctx context.Context) (int64, error) {
+	ctx, done := instrument(ctx, "session", "ReapActiveSessions")
+	defer done()
+	query := `DELETE FROM active_sessions WHERE last_seen < NOW() - make_interval(secs => $1)`
+	ttl := time.Duration(db.activeSessionTTL.Load())
+	tag, err := db.pool.Exec(ctx, query, ttl.Seconds())
+	if err != nil {
+		return 0, err
 	}
+	return tag.RowsAffected(), nil
+}
 
+func (db *PostgresDB) GetActiveUsersInRoom(ctx context.Context, roomID int) ([]*models.ActiveUser, error) {
+	ctx, done := instrument(ctx, "session", "GetActiveUsersInRoom")
+	defer done()
 	query := `
-		SELECT DISTINCT u.id, u.username, u.email, s.connected_at, s.last_seen
+		SELECT u.id, u.username, u.email, MIN(s.connected_at), MAX(s.last_seen), COUNT(*)
 		FROM active_sessions s
 		JOIN users u ON s.user_id = u.id
 		WHERE s.room_id = $1
+		GROUP BY u.id, u.username, u.email
 		ORDER BY u.username`
 	
 	rows, err := db.pool.Query(ctx, query, roomID)
@@ -284,7 +794,7 @@ func (db *PostgresDB) GetActiveUsersInRoom(ctx context.Context, roomID int) ([]*
 	var activeUsers []*models.ActiveUser
 	for rows.Next() {
 		user := &models.ActiveUser{Status: "online"}
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.ConnectedAt, &user.LastSeen); err != nil {
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.ConnectedAt, &user.LastSeen, &user.Connections); err != nil {
 			return nil, err
 		}
 		activeUsers = append(activeUsers, user)
@@ -295,6 +805,8 @@ func (db *PostgresDB) GetActiveUsersInRoom(ctx context.Context, roomID int) ([]*
 
 // Membership Repository Implementation
 func (db *PostgresDB) AddMembership(ctx context.Context, userID, roomID int) error {
+	ctx, done := instrument(ctx, "membership", "AddMembership")
+	defer done()
 	query := `
 		INSERT INTO memberships (user_id, room_id) VALUES ($1, $2)
 		ON CONFLICT (user_id, room_id) DO NOTHING`
@@ -304,12 +816,16 @@ func (db *PostgresDB) AddMembership(ctx context.Context, userID, roomID int) err
 }
 
 func (db *PostgresDB) RemoveMembership(ctx context.Context, userID, roomID int) error {
+	ctx, done := instrument(ctx, "membership", "RemoveMembership")
+	defer done()
 	query := `DELETE FROM memberships WHERE user_id = $1 AND room_id = $2`
 	_, err := db.pool.Exec(ctx, query, userID, roomID)
 	return err
 }
 
 func (db *PostgresDB) IsMember(ctx context.Context, userID, roomID int) (bool, error) {
+	ctx, done := instrument(ctx, "membership", "IsMember")
+	defer done()
 	query := `SELECT EXISTS(SELECT 1 FROM memberships WHERE user_id = $1 AND room_id = $2)`
 	
 	var exists bool
@@ -318,6 +834,8 @@ func (db *PostgresDB) IsMember(ctx context.Context, userID, roomID int) (bool, e
 }
 
 func (db *PostgresDB) GetRoomMembers(ctx context.Context, roomID int) ([]*models.Member, error) {
+	ctx, done := instrument(ctx, "membership", "GetRoomMembers")
+	defer done()
 	query := `
 		SELECT u.id, u.username, u.email
 		FROM memberships m
@@ -341,4 +859,261 @@ func (db *PostgresDB) GetRoomMembers(ctx context.Context, roomID int) ([]*models
 	}
 	
 	return members, nil
-}
\ No newline at end of file
+}
+
+// Rate Limit Repository Implementation
+func (db *PostgresDB) AddAttempt(ctx context.Context, key string, at time.Time) error {
+	ctx, done := instrument(ctx, "rate_limit", "AddAttempt")
+	defer done()
+	query := `INSERT INTO login_attempts (key, attempted_at) VALUES ($1, $2)`
+	_, err := db.pool.Exec(ctx, query, key, at)
+	return err
+}
+
+func (db *PostgresDB) CountAttempts(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	ctx, done := instrument(ctx, "rate_limit", "CountAttempts")
+	defer done()
+	query := `
+		SELECT COUNT(*), COALESCE(MIN(attempted_at), to_timestamp(0))
+		FROM login_attempts
+		WHERE key = $1 AND attempted_at >= $2`
+	
+	var count int
+	var oldest time.Time
+	err := db.pool.QueryRow(ctx, query, key, since).Scan(&count, &oldest)
+	return count, oldest, err
+}
+
+func (db *PostgresDB) ClearAttempts(ctx context.Context, key string) error {
+	ctx, done := instrument(ctx, "rate_limit", "ClearAttempts")
+	defer done()
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM login_attempts WHERE key = $1", key); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM login_lockouts WHERE key = $1", key); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (db *PostgresDB) SetLockout(ctx context.Context, key string, until time.Time) error {
+	ctx, done := instrument(ctx, "rate_limit", "SetLockout")
+	defer done()
+	query := `
+		INSERT INTO login_lockouts (key, locked_until) VALUES ($1, $2)
+		ON CONFLICT (key)
+		DO UPDATE SET locked_until = GREATEST(login_lockouts.locked_until, EXCLUDED.locked_until)`
+	
+	_, err := db.pool.Exec(ctx, query, key, until)
+	return err
+}
+
+func (db *PostgresDB) GetLockout(ctx context.Context, key string) (time.Time, error) {
+	ctx, done := instrument(ctx, "rate_limit", "GetLockout")
+	defer done()
+	query := `SELECT COALESCE(MAX(locked_until), to_timestamp(0)) FROM login_lockouts WHERE key = $1`
+	
+	var until time.Time
+	err := db.pool.QueryRow(ctx, query, key).Scan(&until)
+	return until, err
+}
+
+func (db *PostgresDB) PruneAttempts(ctx context.Context, before time.Time) error {
+	ctx, done := instrument(ctx, "rate_limit", "PruneAttempts")
+	defer done()
+	if _, err := db.pool.Exec(ctx, "DELETE FROM login_attempts WHERE attempted_at < $1", before); err != nil {
+		return err
+	}
+
+	_, err := db.pool.Exec(ctx, "DELETE FROM login_lockouts WHERE locked_until < NOW()")
+	return err
+}
+
+// Audit Repository Implementation
+func (db *PostgresDB) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	ctx, done := instrument(ctx, "audit", "CreateAuditEvent")
+	defer done()
+	query := `
+		INSERT INTO audit_events (actor_id, actor_name, action, target_type, target_id, ip, metadata, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		RETURNING id, created_at`
+	
+	var metadata interface{}
+	if len(event.Metadata) > 0 {
+		metadata = string(event.Metadata)
+	}
+
+	return db.pool.QueryRow(ctx, query,
+		event.ActorID, event.ActorName, event.Action, event.TargetType, event.TargetID, event.IP, metadata,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (db *PostgresDB) ListAuditEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	ctx, done := instrument(ctx, "audit", "ListAuditEvents")
+	defer done()
+	var conditions []string
+	var args []interface{}
+	addCondition := func(clause string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.ActorID != nil {
+		addCondition("actor_id = $%d", *filter.ActorID)
+	}
+	if filter.Action != "" {
+		addCondition("starts_with(action, $%d)", filter.Action)
+	}
+	if filter.TargetType != "" {
+		addCondition("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		addCondition("target_id = $%d", filter.TargetID)
+	}
+	if filter.Since != nil {
+		addCondition("created_at >= $%d", *filter.Since)
+	}
+	if filter.Until != nil {
+		addCondition("created_at < $%d", *filter.Until)
+	}
+	if filter.AfterID > 0 {
+		addCondition("id > $%d", filter.AfterID)
+	}
+
+	query := `
+		SELECT id, actor_id, actor_name, action, target_type, target_id, ip, COALESCE(metadata::text, ''), created_at
+		FROM audit_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if filter.Ascending {
+		query += " ORDER BY id ASC"
+	} else {
+		query += " ORDER BY id DESC"
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := db.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+	for rows.Next() {
+		event := &models.AuditEvent{}
+		var metadata string
+		if err := rows.Scan(
+			&event.ID, &event.ActorID, &event.ActorName, &event.Action, &event.TargetType,
+			&event.TargetID, &event.IP, &metadata, &event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if metadata != "" {
+			event.Metadata = []byte(metadata)
+		}
+		events = append(events, event)
+	}
+	
+	return events, nil
+}
+// Health Repository Implementation
+
+// requiredTables lists the tables schema.sql creates; readiness fails until
+// every one of them exists.
+var requiredTables = []string{
+	"users", "rooms", "messages", "memberships", "active_sessions", "recovery_codes",
+	"login_attempts", "login_lockouts", "user_sessions", "audit_events", "cluster_payloads",
+	"message_reactions",
+}
+
+// requiredColumns lists the columns schema.sql adds to existing tables, as
+// table.column; readiness fails until every one of them exists.
+var requiredColumns = []string{
+	"users.totp_secret", "users.totp_enabled", "users.totp_last_counter", "users.is_admin",
+	"users.is_active", "users.must_reset_password", "rooms.message_rate", "rooms.message_burst",
+	"rooms.slow_consumer_policy", "rooms.last_seq", "messages.edited_at", "messages.client_id",
+	"messages.seq",
+}
+
+func (db *PostgresDB) Ping(ctx context.Context) error {
+	ctx, done := instrument(ctx, "health", "Ping")
+	defer done()
+
+	return db.pool.Ping(ctx)
+}
+
+func (db *PostgresDB) CheckSchema(ctx context.Context) error {
+	ctx, done := instrument(ctx, "health", "CheckSchema")
+	defer done()
+
+	query := `
+		SELECT name FROM unnest($1::text[]) AS name
+		WHERE to_regclass('public.' || name) IS NULL`
+
+	missing, err := db.missingNames(ctx, query, requiredTables)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+
+	query = `
+		SELECT name FROM unnest($1::text[]) AS name
+		WHERE NOT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = 'public'
+			  AND table_name = split_part(name, '.', 1)
+			  AND column_name = split_part(name, '.', 2))`
+
+	missing, err = db.missingNames(ctx, query, requiredColumns)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing columns: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// missingNames runs a query that returns the names it was given which don't
+// exist in the schema.
+func (db *PostgresDB) missingNames(ctx context.Context, query string, names []string) ([]string, error) {
+	rows, err := db.pool.Query(ctx, query, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		missing = append(missing, name)
+	}
+	return missing, rows.Err()
+}
+
+func (db *PostgresDB) PoolStats() models.PoolStats {
+	stat := db.pool.Stat()
+	return models.PoolStats{
+		AcquiredConns: stat.AcquiredConns(),
+		IdleConns:     stat.IdleConns(),
+		TotalConns:    stat.TotalConns(),
+		MaxConns:      stat.MaxConns(),
+		AcquireCount:  stat.AcquireCount(),
+		EmptyAcquires: stat.EmptyAcquireCount(),
+	}
+}
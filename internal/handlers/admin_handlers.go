@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/services"
+	ws "chat-app/internal/websocket"
+	"chat-app/pkg/logger"
+)
+
+type AdminHandlers struct {
+	adminService *services.AdminService
+	hubManager   *ws.Manager
+}
+
+func NewAdminHandlers(adminService *services.AdminService, hubManager *ws.Manager) *AdminHandlers {
+	return &AdminHandlers{
+		adminService: adminService,
+		hubManager:   hubManager,
+	}
+}
+
+func (h *AdminHandlers) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	users, err := h.adminService.ListUsers(r.Context(), query.Get("q"), limit, offset)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Admin list users error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+func (h *AdminHandlers) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	admin := userFromContext(r.Context())
+
+	userID, err := getIDFromPath(r, 3)
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.adminService.DeactivateUser(r.Context(), admin, userID); err != nil {
+		logger.ErrorContext(r.Context(), "Admin deactivate user error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.hubManager.DisconnectUser(userID, "account deactivated")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("user deactivated"))
+}
+
+func (h *AdminHandlers) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	admin := userFromContext(r.Context())
+
+	userID, err := getIDFromPath(r, 3)
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.adminService.ReactivateUser(r.Context(), admin, userID); err != nil {
+		logger.ErrorContext(r.Context(), "Admin reactivate user error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("user reactivated"))
+}
+
+func (h *AdminHandlers) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	admin := userFromContext(r.Context())
+
+	userID, err := getIDFromPath(r, 3)
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.adminService.ForcePasswordReset(r.Context(), admin, userID); err != nil {
+		logger.ErrorContext(r.Context(), "Admin force password reset error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.hubManager.DisconnectUser(userID, "password reset required")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("password reset required"))
+}
+
+func (h *AdminHandlers) GrantAdmin(w http.ResponseWriter, r *http.Request) {
+	h.setAdmin(w, r, true)
+}
+
+func (h *AdminHandlers) RevokeAdmin(w http.ResponseWriter, r *http.Request) {
+	h.setAdmin(w, r, false)
+}
+
+func (h *AdminHandlers) setAdmin(w http.ResponseWriter, r *http.Request, isAdmin bool) {
+	admin := userFromContext(r.Context())
+
+	userID, err := getIDFromPath(r, 3)
+	if err != nil {
+		http.Error(w, "invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.adminService.SetAdmin(r.Context(), admin, userID, isAdmin); err != nil {
+		logger.ErrorContext(r.Context(), "Admin set role error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("admin role updated"))
+}
+
+func (h *AdminHandlers) DeleteRoom(w http.ResponseWriter, r *http.Request) {
+	admin := userFromContext(r.Context())
+
+	roomID, err := getIDFromPath(r, 3)
+	if err != nil {
+		http.Error(w, "invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.adminService.DeleteRoom(r.Context(), admin, roomID); err != nil {
+		logger.ErrorContext(r.Context(), "Admin delete room error: %v", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.hubManager.CloseRoom(roomID, "room deleted")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("room deleted successfully"))
+}
+
+func (h *AdminHandlers) GetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.adminService.GetStats(r.Context())
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Admin stats error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (h *AdminHandlers) QueryAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.adminService.QueryAuditLog(r.Context(), filter)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Admin audit query error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// ExportAuditLog streams all matching events as JSON lines.
+func (h *AdminHandlers) ExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+
+	encoder := json.NewEncoder(w)
+	err = h.adminService.ExportAuditLog(r.Context(), filter, func(event *models.AuditEvent) error {
+		return encoder.Encode(event)
+	})
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Admin audit export error: %v", err)
+	}
+}
+
+// LogLevel reports the current log level on GET and changes it on PUT.
+func (h *AdminHandlers) LogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		if err := logger.SetLevel(req.Level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.WarnContext(r.Context(), "Log level changed to %s by %s", logger.GetLevel(), userFromContext(r.Context()).Username)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"level": logger.GetLevel()})
+}
+
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+
+	if value := query.Get("actor_id"); value != "" {
+		actorID, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid actor_id")
+		}
+		filter.ActorID = &actorID
+	}
+
+	if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since, expected RFC3339")
+		}
+		filter.Since = &since
+	}
+
+	if value := query.Get("until"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid until, expected RFC3339")
+		}
+		filter.Until = &until
+	}
+
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	filter.Offset, _ = strconv.Atoi(query.Get("offset"))
+
+	return filter, nil
+}
+
+// getIDFromPath parses the numeric path segment at index, e.g. 3 for
+// /admin/users/{id}/deactivate.
+func getIDFromPath(r *http.Request, index int) (int, error) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) <= index {
+		return 0, fmt.Errorf("invalid path")
+	}
+
+	return strconv.Atoi(parts[index])
+}
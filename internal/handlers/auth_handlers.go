@@ -2,10 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 
 	"chat-app/internal/auth"
 	"chat-app/internal/models"
+	"chat-app/internal/ratelimit"
 	"chat-app/pkg/logger"
 )
 
@@ -26,9 +32,11 @@ func (h *AuthHandlers) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	fillDeviceInfo(r, &req.DeviceInfo)
+
 	response, err := h.authService.Register(r.Context(), &req)
 	if err != nil {
-		logger.Error("Registration error: %v", err)
+		logger.ErrorContext(r.Context(), "Registration error: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -45,13 +53,166 @@ func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	fillDeviceInfo(r, &req.DeviceInfo)
+
 	response, err := h.authService.Login(r.Context(), &req)
+	if writeRateLimited(w, err) {
+		logger.InfoContext(r.Context(), "Login throttled for %s from %s", req.Email, req.IP)
+		return
+	}
 	if err != nil {
-		logger.Error("Login error: %v", err)
+		logger.ErrorContext(r.Context(), "Login error: %v", err)
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+func (h *AuthHandlers) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req models.MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	fillDeviceInfo(r, &req.DeviceInfo)
+
+	response, err := h.authService.VerifyMFA(r.Context(), &req)
+	if writeRateLimited(w, err) {
+		logger.InfoContext(r.Context(), "MFA verification throttled from %s", req.IP)
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(r.Context(), "MFA verification error: %v", err)
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *AuthHandlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	fillDeviceInfo(r, &req.DeviceInfo)
+
+	response, err := h.authService.ResetPassword(r.Context(), &req)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Password reset error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *AuthHandlers) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	response, err := h.authService.EnrollTOTP(r.Context(), user)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "TOTP enroll error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *AuthHandlers) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TOTPConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.authService.ConfirmTOTP(r.Context(), user, req.Code)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "TOTP confirm error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *AuthHandlers) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TOTPDisableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authService.DisableTOTP(r.Context(), user, &req); err != nil {
+		logger.ErrorContext(r.Context(), "TOTP disable error: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("two-factor authentication disabled"))
+}
+
+func (h *AuthHandlers) getUserFromToken(r *http.Request) (*models.User, error) {
+	tokenStr := r.URL.Query().Get("token")
+	if tokenStr == "" {
+		return nil, fmt.Errorf("missing token")
+	}
+
+	return h.authService.GetUserFromToken(r.Context(), tokenStr)
+}
+
+// writeRateLimited responds with 429 and Retry-After if err is a rate limit error.
+func writeRateLimited(w http.ResponseWriter, err error) bool {
+	var limited *ratelimit.LimitedError
+	if !errors.As(err, &limited) {
+		return false
+	}
+
+	seconds := int(math.Ceil(limited.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "too many attempts", http.StatusTooManyRequests)
+	return true
+}
+
+func fillDeviceInfo(r *http.Request, device *models.DeviceInfo) {
+	device.UserAgent = r.UserAgent()
+	device.IP = clientIP(r)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"chat-app/internal/database"
+	"chat-app/internal/models"
+	ws "chat-app/internal/websocket"
+)
+
+type HealthHandlers struct {
+	db           database.Database
+	hubManager   *ws.Manager
+	readyTimeout time.Duration
+	version      string
+	commit       string
+	startedAt    time.Time
+	shuttingDown atomic.Bool
+}
+
+func NewHealthHandlers(db database.Database, hubManager *ws.Manager, readyTimeout time.Duration, version string) *HealthHandlers {
+	h := &HealthHandlers{
+		db:           db,
+		hubManager:   hubManager,
+		readyTimeout: readyTimeout,
+		version:      version,
+		startedAt:    time.Now(),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				h.commit = setting.Value
+			}
+		}
+	}
+
+	return h
+}
+
+// BeginShutdown makes readiness fail so load balancers stop routing new
+// traffic while in-flight work drains.
+func (h *HealthHandlers) BeginShutdown() {
+	h.shuttingDown.Store(true)
+}
+
+// Liveness reports that the process is up and serving HTTP.
+func (h *HealthHandlers) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, models.HealthStatus{Status: "ok"})
+}
+
+// Readiness reports whether the server can take traffic: the database answers
+// within the timeout, the schema is loaded and shutdown has not begun.
+func (h *HealthHandlers) Readiness(w http.ResponseWriter, r *http.Request) {
+	status := h.check(r.Context())
+
+	code := http.StatusOK
+	if status.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, status)
+}
+
+func (h *HealthHandlers) Status(w http.ResponseWriter, r *http.Request) {
+	hubs, clients := h.hubManager.Stats()
+
+	status := models.ServerStatus{
+		Status:        h.check(r.Context()).Status,
+		Version:       h.version,
+		Commit:        h.commit,
+		GoVersion:     runtime.Version(),
+		StartedAt:     h.startedAt,
+		UptimeSeconds: int64(time.Since(h.startedAt).Seconds()),
+		Hubs:          hubs,
+		Clients:       clients,
+		Database:      h.db.PoolStats(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (h *HealthHandlers) check(ctx context.Context) models.HealthStatus {
+	status := models.HealthStatus{Status: "ok", Checks: map[string]string{}}
+	fail := func(name, reason string) {
+		status.Status = "unavailable"
+		status.Checks[name] = reason
+	}
+
+	if h.shuttingDown.Load() {
+		fail("shutdown", "shutting down")
+	} else {
+		status.Checks["shutdown"] = "ok"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.readyTimeout)
+	defer cancel()
+
+	if err := h.db.Ping(ctx); err != nil {
+		fail("database", err.Error())
+		return status
+	}
+	status.Checks["database"] = "ok"
+
+	if err := h.db.CheckSchema(ctx); err != nil {
+		fail("schema", err.Error())
+	} else {
+		status.Checks["schema"] = "ok"
+	}
+
+	return status
+}
+
+func writeHealth(w http.ResponseWriter, code int, status models.HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -13,6 +13,11 @@ import (
 	"chat-app/pkg/logger"
 )
 
+const (
+	defaultMessagePage = 100
+	maxMessagePage     = 500
+)
+
 type RoomHandlers struct {
 	roomService *services.RoomService
 	authService *auth.Service
@@ -40,7 +45,7 @@ func (h *RoomHandlers) CreateRoom(w http.ResponseWriter, r *http.Request) {
 
 	room, err := h.roomService.CreateRoom(r.Context(), &req, user.ID)
 	if err != nil {
-		logger.Error("Create room error: %v", err)
+		logger.ErrorContext(r.Context(), "Create room error: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -59,7 +64,7 @@ func (h *RoomHandlers) ListRooms(w http.ResponseWriter, r *http.Request) {
 
 	rooms, err := h.roomService.ListUserRooms(r.Context(), user.ID)
 	if err != nil {
-		logger.Error("List rooms error: %v", err)
+		logger.ErrorContext(r.Context(), "List rooms error: %v", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -82,7 +87,7 @@ func (h *RoomHandlers) DeleteRoom(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.roomService.DeleteRoom(r.Context(), roomID, user.ID); err != nil {
-		logger.Error("Delete room error: %v", err)
+		logger.ErrorContext(r.Context(), "Delete room error: %v", err)
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
@@ -111,7 +116,7 @@ func (h *RoomHandlers) InviteUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.roomService.InviteUser(r.Context(), roomID, user.ID, req.Email); err != nil {
-		logger.Error("Invite user error: %v", err)
+		logger.ErrorContext(r.Context(), "Invite user error: %v", err)
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
@@ -134,7 +139,7 @@ func (h *RoomHandlers) LeaveRoom(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.roomService.LeaveRoom(r.Context(), user.ID, roomID); err != nil {
-		logger.Error("Leave room error: %v", err)
+		logger.ErrorContext(r.Context(), "Leave room error: %v", err)
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
@@ -158,7 +163,7 @@ func (h *RoomHandlers) GetRoomMembers(w http.ResponseWriter, r *http.Request) {
 
 	members, err := h.roomService.GetRoomMembers(r.Context(), roomID, user.ID)
 	if err != nil {
-		logger.Error("Get room members error: %v", err)
+		logger.ErrorContext(r.Context(), "Get room members error: %v", err)
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
@@ -182,7 +187,7 @@ func (h *RoomHandlers) GetActiveUsers(w http.ResponseWriter, r *http.Request) {
 
 	activeUsers, err := h.roomService.GetActiveUsers(r.Context(), roomID, user.ID)
 	if err != nil {
-		logger.Error("Get active users error: %v", err)
+		logger.ErrorContext(r.Context(), "Get active users error: %v", err)
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
@@ -195,6 +200,51 @@ func (h *RoomHandlers) GetActiveUsers(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetMessages pages through a room's messages by sequence number:
+// ?after_seq=N returns the messages numbered after N, up to ?limit.
+func (h *RoomHandlers) GetMessages(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromToken(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := h.getRoomIDFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	var afterSeq int64
+	if v := r.URL.Query().Get("after_seq"); v != "" {
+		if afterSeq, err = strconv.ParseInt(v, 10, 64); err != nil || afterSeq < 0 {
+			http.Error(w, "invalid after_seq", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultMessagePage
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxMessagePage {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxMessagePage), http.StatusBadRequest)
+			return
+		}
+	}
+
+	messages, err := h.roomService.GetMessages(r.Context(), roomID, user.ID, afterSeq, limit)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Get messages error: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if messages == nil {
+		messages = []*models.Message{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
 func (h *RoomHandlers) getUserFromToken(r *http.Request) (*models.User, error) {
 	tokenStr := r.URL.Query().Get("token")
 	if tokenStr == "" {
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"chat-app/internal/auth"
+	"chat-app/internal/models"
+	ws "chat-app/internal/websocket"
+	"chat-app/pkg/logger"
+)
+
+type SessionHandlers struct {
+	authService *auth.Service
+	hubManager  *ws.Manager
+}
+
+func NewSessionHandlers(authService *auth.Service, hubManager *ws.Manager) *SessionHandlers {
+	return &SessionHandlers{
+		authService: authService,
+		hubManager:  hubManager,
+	}
+}
+
+func (h *SessionHandlers) ListSessions(w http.ResponseWriter, r *http.Request) {
+	user, sessionID, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.authService.ListSessions(r.Context(), user.ID, sessionID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "List sessions error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (h *SessionHandlers) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, _, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// /me/sessions/{id}
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 4 || parts[3] == "" {
+		http.Error(w, "invalid session ID", http.StatusBadRequest)
+		return
+	}
+	sessionID := parts[3]
+
+	if err := h.authService.RevokeSession(r.Context(), user.ID, sessionID); err != nil {
+		logger.ErrorContext(r.Context(), "Revoke session error: %v", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Drop any live connections opened with the revoked token
+	h.hubManager.DisconnectSession(sessionID)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("session revoked"))
+}
+
+func (h *SessionHandlers) authenticate(r *http.Request) (*models.User, string, error) {
+	tokenStr := r.URL.Query().Get("token")
+	if tokenStr == "" {
+		return nil, "", fmt.Errorf("missing token")
+	}
+
+	return h.authService.Authenticate(r.Context(), tokenStr)
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/internal/auth"
+	"chat-app/internal/models"
+	"chat-app/internal/services"
+	ws "chat-app/internal/websocket"
+	"chat-app/pkg/logger"
+)
+
+// StreamHandlers serve rooms to clients that can't keep a WebSocket open,
+// such as those behind proxies that block upgrades: events arrive over SSE or
+// long polling and messages are sent with a POST.
+type StreamHandlers struct {
+	authService *auth.Service
+	roomService *services.RoomService
+	hubManager  *ws.Manager
+}
+
+func NewStreamHandlers(authService *auth.Service, roomService *services.RoomService, hubManager *ws.Manager) *StreamHandlers {
+	return &StreamHandlers{
+		authService: authService,
+		roomService: roomService,
+		hubManager:  hubManager,
+	}
+}
+
+// Events streams the room as Server-Sent Events. Reconnecting EventSources
+// send Last-Event-ID, which holds the sequence number of the last message
+// they saw; last_event_id does the same for the first connection.
+func (h *StreamHandlers) Events(w http.ResponseWriter, r *http.Request) {
+	user, authSessionID, roomID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	lastSeq, err := parseLastSeq(lastEventID)
+	if err != nil {
+		http.Error(w, "invalid last event ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.hubManager.ServeEvents(w, r, roomID, user.ID, user.Username, authSessionID, lastSeq); err != nil {
+		h.writeStreamError(w, r, err)
+	}
+}
+
+// Poll waits for the room's events. The first poll passes after_seq, or
+// nothing for the recent history, and every later one the returned poll_id.
+func (h *StreamHandlers) Poll(w http.ResponseWriter, r *http.Request) {
+	user, authSessionID, roomID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	lastSeq, err := parseLastSeq(r.URL.Query().Get("after_seq"))
+	if err != nil {
+		http.Error(w, "invalid after_seq", http.StatusBadRequest)
+		return
+	}
+
+	// The poll may wait longer than the server's write timeout allows
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(h.hubManager.PollTimeout()))
+
+	resp, err := h.hubManager.Poll(r.Context(), r.URL.Query().Get("poll_id"), roomID, user.ID, user.Username, authSessionID, lastSeq)
+	if errors.Is(err, ws.ErrPollNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.writeStreamError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PostMessage sends a chat message the way a WebSocket send_message does and
+// responds with the same ack.
+func (h *StreamHandlers) PostMessage(w http.ResponseWriter, r *http.Request) {
+	user, _, roomID, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	ack, err := h.hubManager.PostMessage(r.Context(), roomID, user.ID, user.Username, req)
+	if writeRateLimited(w, err) {
+		return
+	}
+	if ws.RequestErrorCode(err) == models.ErrorCodeBadRequest {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.writeStreamError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ack)
+}
+
+// authorize authenticates the request and checks the user may access the
+// room in its path.
+func (h *StreamHandlers) authorize(w http.ResponseWriter, r *http.Request) (*models.User, string, int, bool) {
+	tokenStr := r.URL.Query().Get("token")
+	if tokenStr == "" {
+		http.Error(w, "missing token", http.StatusUnauthorized)
+		return nil, "", 0, false
+	}
+	user, authSessionID, err := h.authService.Authenticate(r.Context(), tokenStr)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return nil, "", 0, false
+	}
+
+	roomID, err := getIDFromPath(r, 2)
+	if err != nil {
+		http.Error(w, "invalid room ID", http.StatusBadRequest)
+		return nil, "", 0, false
+	}
+
+	canAccess, err := h.roomService.CanUserAccessRoom(r.Context(), user.ID, roomID)
+	if err != nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return nil, "", 0, false
+	}
+	if !canAccess {
+		http.Error(w, "not a member of this room", http.StatusForbidden)
+		return nil, "", 0, false
+	}
+	return user, authSessionID, roomID, true
+}
+
+func (h *StreamHandlers) writeStreamError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ws.ErrShuttingDown) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	logger.ErrorContext(r.Context(), "Room stream error: %v", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+// parseLastSeq reads a message sequence number, returning -1 when it is
+// absent.
+func parseLastSeq(v string) (int64, error) {
+	if v == "" {
+		return -1, nil
+	}
+	seq, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, errors.New("invalid sequence number")
+	}
+	return seq, nil
+}
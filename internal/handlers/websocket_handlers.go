@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"chat-app/internal/auth"
 	"chat-app/internal/database"
@@ -20,14 +23,18 @@ type WebSocketHandlers struct {
 	upgrader    websocket.Upgrader
 }
 
-func NewWebSocketHandlers(authService *auth.Service, roomService *services.RoomService, hubManager *ws.Manager, db database.Database) *WebSocketHandlers {
+// NewWebSocketHandlers creates the WebSocket handlers. compression enables
+// permessage-deflate for clients that offer it.
+func NewWebSocketHandlers(authService *auth.Service, roomService *services.RoomService, hubManager *ws.Manager, db database.Database, compression bool) *WebSocketHandlers {
 	return &WebSocketHandlers{
 		authService: authService,
 		roomService: roomService,
 		hubManager:  hubManager,
 		db:          db,
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true }, // Configure for production
+			CheckOrigin:       func(r *http.Request) bool { return true }, // Configure for production
+			Subprotocols:      ws.Subprotocols(),
+			EnableCompression: compression,
 		},
 	}
 }
@@ -41,60 +48,84 @@ func (h *WebSocketHandlers) HandleWebSocket(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Validate token and get user
-	user, err := h.authService.GetUserFromToken(r.Context(), tokenStr)
+	user, authSessionID, err := h.authService.Authenticate(r.Context(), tokenStr)
 	if err != nil {
 		http.Error(w, "invalid token", http.StatusUnauthorized)
 		return
 	}
 
-	// Get room from query parameter
-	roomName := r.URL.Query().Get("room")
-	if roomName == "" {
-		roomName = "general"
+	// A multiplexed connection serves the user rather than a room, and
+	// subscribes to rooms with subscribe frames
+	multiplexed := r.URL.Query().Get("mode") == "multiplex"
+
+	var roomID int
+	if !multiplexed {
+		// Get room from query parameter
+		roomName := r.URL.Query().Get("room")
+		if roomName == "" {
+			roomName = "general"
+		}
+
+		// Get or create room
+		roomID, err = h.db.GetOrCreateRoom(r.Context(), roomName)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Error creating room: %v", err)
+			http.Error(w, "error accessing room", http.StatusInternalServerError)
+			return
+		}
+
+		// Check if user can access room
+		canAccess, err := h.roomService.CanUserAccessRoom(r.Context(), user.ID, roomID)
+		if err != nil {
+			http.Error(w, "error checking room access", http.StatusInternalServerError)
+			return
+		}
+		if !canAccess {
+			http.Error(w, "not a member of this room", http.StatusForbidden)
+			return
+		}
 	}
 
-	// Get or create room
-	roomID, err := h.db.GetOrCreateRoom(r.Context(), roomName)
-	if err != nil {
-		logger.Error("Error creating room: %v", err)
-		http.Error(w, "error accessing room", http.StatusInternalServerError)
-		return
-	}
-
-	// Check if user can access room
-	canAccess, err := h.roomService.CanUserAccessRoom(r.Context(), user.ID, roomID)
-	if err != nil {
-		http.Error(w, "error checking room access", http.StatusInternalServerError)
-		return
-	}
-	if !canAccess {
-		http.Error(w, "not a member of this room", http.StatusForbidden)
-		return
+	// Clients reconnecting pass the last message sequence number they saw
+	lastSeq := int64(-1)
+	if v := r.URL.Query().Get("last_seq"); v != "" {
+		seq, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || seq < 0 {
+			http.Error(w, "invalid last_seq", http.StatusBadRequest)
+			return
+		}
+		lastSeq = seq
 	}
 
 	// Upgrade connection to WebSocket
-	conn, err := h.upgrader.Upgrade(w, r, nil)
+	conn, err := ws.Upgrade(&h.upgrader, w, r)
 	if err != nil {
-		logger.Error("Upgrade error: %v", err)
+		logger.ErrorContext(r.Context(), "Upgrade error: %v", err)
 		return
 	}
 
-	// Get hub for room
-	hub := h.hubManager.GetHubForRoom(roomID)
+	// The session outlives the request, so keep only its values
+	ctx := context.WithoutCancel(r.Context())
 
 	// Create client
-	client, err := ws.NewClient(hub, conn, user.ID, user.Username, roomID, h.db)
+	client, err := ws.NewClient(ctx, h.hubManager, conn, h.roomService, user.ID, user.Username, authSessionID, h.db, multiplexed)
 	if err != nil {
-		logger.Error("Error creating client: %v", err)
+		logger.ErrorContext(r.Context(), "Error creating client: %v", err)
 		conn.Close()
 		return
 	}
 
-	// Register client with hub
-	hub.Register <- client
-
-	// Send recent messages to the new client
-	go client.SendRecentMessages()
+	if !multiplexed {
+		if err := client.Subscribe(roomID, lastSeq); err != nil {
+			if errors.Is(err, ws.ErrShuttingDown) {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, err.Error()))
+			} else {
+				logger.ErrorContext(r.Context(), "Error joining room %d: %v", roomID, err)
+			}
+			conn.Close()
+			return
+		}
+	}
 
 	// Start client pumps
 	go client.WritePump()
@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gochat"
+
+// HTTP metrics
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+)
+
+// WebSocket metrics
+var (
+	WSConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Currently connected WebSocket clients.",
+	})
+
+	WSConnectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections_total",
+		Help:      "WebSocket clients connected since start.",
+	})
+
+	WSMessagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_messages_received_total",
+		Help:      "Frames received from clients.",
+	})
+
+	WSMessagesRateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_messages_rate_limited_total",
+		Help:      "Frames rejected by flood control.",
+	})
+
+	WSBroadcasts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_broadcasts_total",
+		Help:      "Messages broadcast by hubs.",
+	})
+
+	WSMessagesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_messages_sent_total",
+		Help:      "Frames written to clients.",
+	})
+
+	WSMessagesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_messages_dropped_total",
+		Help:      "Frames dropped because a client's send buffer was full.",
+	})
+
+	WSSlowClientsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_slow_clients_dropped_total",
+		Help:      "Clients disconnected for not keeping up with broadcasts.",
+	})
+
+	WSSendQueueFull = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_send_queue_full_total",
+		Help:      "Frames that found a client's send buffer full, by the room's slow-consumer policy.",
+	}, []string{"policy"})
+
+	WSFramesCoalesced = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_frames_coalesced_total",
+		Help:      "Presence and typing frames merged into newer state for slow clients.",
+	})
+
+	WSSendLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "websocket_send_lag_seconds",
+		Help:      "Time frames spend queued for a client before they are written.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+
+	WSReplays = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_replays_total",
+		Help:      "Reconnects that resumed from a sequence number, by outcome.",
+	}, []string{"outcome"})
+
+	StreamClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stream_clients",
+		Help:      "Clients connected over SSE or long polling, by transport.",
+	}, []string{"transport"})
+
+	WSFramesEncoded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_frames_encoded_total",
+		Help:      "Hub frames converted for clients of a binary subprotocol, by codec.",
+	}, []string{"codec"})
+
+	WSCompressedFrames = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_compressed_frames_total",
+		Help:      "Frames sent with permessage-deflate.",
+	})
+
+	WSCompressionInputBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_compression_input_bytes_total",
+		Help:      "Size of compressed frames before compression.",
+	})
+
+	WSCompressionOutputBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_compression_output_bytes_total",
+		Help:      "Bytes compressed frames took on the wire, including framing.",
+	})
+
+	WSCompressionSavedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_compression_saved_bytes_total",
+		Help:      "Bytes permessage-deflate saved over sending frames uncompressed.",
+	})
+)
+
+// Hub lifecycle metrics
+var (
+	HubsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "hubs_active",
+		Help:      "Room hubs currently held by the manager.",
+	})
+
+	HubsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hubs_created_total",
+		Help:      "Room hubs created since start.",
+	})
+
+	HubsRemoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hubs_removed_total",
+		Help:      "Room hubs removed by reason.",
+	}, []string{"reason"})
+)
+
+// Cluster fan-out metrics
+var (
+	ClusterPublished = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cluster_events_published_total",
+		Help:      "Hub events published to other instances.",
+	})
+
+	ClusterReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cluster_events_received_total",
+		Help:      "Hub events received from other instances.",
+	})
+
+	ClusterDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cluster_events_dropped_total",
+		Help:      "Hub events that could not be relayed, by reason.",
+	}, []string{"reason"})
+)
+
+// Database metrics
+var (
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database call latency by repository and operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"repository", "operation"})
+)
+
+// ObserveQuery starts timing a database call; call the returned func when it
+// completes.
+func ObserveQuery(repository, operation string) func() {
+	start := time.Now()
+	return func() {
+		DBQueryDuration.WithLabelValues(repository, operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+// RegisterPoolStats exports pgx pool statistics gathered on each scrape.
+func RegisterPoolStats(stat func() *pgxpool.Stat) {
+	prometheus.MustRegister(&poolCollector{stat: stat})
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+var (
+	poolAcquiredConns = prometheus.NewDesc(namespace+"_db_pool_acquired_connections", "Connections currently in use.", nil, nil)
+	poolIdleConns     = prometheus.NewDesc(namespace+"_db_pool_idle_connections", "Idle connections in the pool.", nil, nil)
+	poolTotalConns    = prometheus.NewDesc(namespace+"_db_pool_total_connections", "Total connections in the pool.", nil, nil)
+	poolMaxConns      = prometheus.NewDesc(namespace+"_db_pool_max_connections", "Maximum pool size.", nil, nil)
+	poolAcquires      = prometheus.NewDesc(namespace+"_db_pool_acquires_total", "Successful connection acquisitions.", nil, nil)
+	poolEmptyAcquires = prometheus.NewDesc(namespace+"_db_pool_empty_acquires_total", "Acquisitions that had to wait for a connection.", nil, nil)
+	poolAcquireWait   = prometheus.NewDesc(namespace+"_db_pool_acquire_wait_seconds_total", "Time spent waiting for connections.", nil, nil)
+)
+
+type poolCollector struct {
+	stat func() *pgxpool.Stat
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolTotalConns
+	ch <- poolMaxConns
+	ch <- poolAcquires
+	ch <- poolEmptyAcquires
+	ch <- poolAcquireWait
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireWait, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
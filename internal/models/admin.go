@@ -0,0 +1,11 @@
+package models
+
+type ServerStats struct {
+	Users            int `json:"users"`
+	DeactivatedUsers int `json:"deactivated_users"`
+	Admins           int `json:"admins"`
+	Rooms            int `json:"rooms"`
+	PublicRooms      int `json:"public_rooms"`
+	Messages         int `json:"messages"`
+	ActiveSessions   int `json:"active_sessions"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type HealthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type PoolStats struct {
+	AcquiredConns int32 `json:"acquired_conns"`
+	IdleConns     int32 `json:"idle_conns"`
+	TotalConns    int32 `json:"total_conns"`
+	MaxConns      int32 `json:"max_conns"`
+	AcquireCount  int64 `json:"acquire_count"`
+	EmptyAcquires int64 `json:"empty_acquire_count"`
+}
+
+type ServerStatus struct {
+	Status        string    `json:"status"`
+	Version       string    `json:"version"`
+	Commit        string    `json:"commit,omitempty"`
+	GoVersion     string    `json:"go_version"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Hubs          int       `json:"hubs"`
+	Clients       int       `json:"clients"`
+	Database      PoolStats `json:"database"`
+}
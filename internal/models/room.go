@@ -3,20 +3,26 @@ package models
 import "time"
 
 type Room struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	IsPublic  bool      `json:"is_public"`
-	OwnerID   int       `json:"owner_id"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                 int       `json:"id"`
+	Name               string    `json:"name"`
+	IsPublic           bool      `json:"is_public"`
+	OwnerID            int       `json:"owner_id"`
+	MessageRate        *float64  `json:"message_rate,omitempty"`
+	MessageBurst       *int      `json:"message_burst,omitempty"`
+	SlowConsumerPolicy *string   `json:"slow_consumer_policy,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
 }
 
 type Message struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	RoomID    int       `json:"room_id"`
-	Content   string    `json:"content"`
-	Username  string    `json:"username,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	RoomID    int        `json:"room_id"`
+	Content   string     `json:"content"`
+	Seq       int64      `json:"seq"`
+	Username  string     `json:"username,omitempty"`
+	ClientID  string     `json:"client_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
 }
 
 type ActiveSession struct {
@@ -34,14 +40,27 @@ type ActiveUser struct {
 	Email       string    `json:"email"`
 	ConnectedAt time.Time `json:"connected_at"`
 	LastSeen    time.Time `json:"last_seen"`
+	Connections int       `json:"connections"`
 	Status      string    `json:"status"`
 }
 
 type CreateRoomRequest struct {
-	Name     string `json:"name"`
-	IsPublic bool   `json:"is_public"`
+	Name               string   `json:"name"`
+	IsPublic           bool     `json:"is_public"`
+	MessageRate        *float64 `json:"message_rate,omitempty"`
+	MessageBurst       *int     `json:"message_burst,omitempty"`
+	SlowConsumerPolicy *string  `json:"slow_consumer_policy,omitempty"`
 }
 
+// Slow-consumer policies decide what happens when a client can't keep up
+// with a room: it is disconnected, loses its oldest presence and typing
+// frames, or has those merged into the latest state.
+const (
+	SlowConsumerDisconnect = "disconnect"
+	SlowConsumerDropOldest = "drop_oldest"
+	SlowConsumerCoalesce   = "coalesce"
+)
+
 type InviteRequest struct {
 	Email string `json:"email"`
 }
@@ -7,21 +7,84 @@ type User struct {
 	Username     string    `json:"username"`
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"-"`
+	TOTPSecret   string    `json:"-"`
+	TOTPEnabled  bool      `json:"totp_enabled"`
+	IsAdmin      bool      `json:"is_admin"`
+	IsActive     bool      `json:"is_active"`
+	MustReset    bool      `json:"must_reset_password"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// DeviceInfo describes the client a session is issued to. Only the device
+// name is supplied by the client; the rest is filled in from the request.
+type DeviceInfo struct {
+	DeviceName string `json:"device_name"`
+	UserAgent  string `json:"-"`
+	IP         string `json:"-"`
+}
+
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	DeviceInfo
 }
 
 type RegisterRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	DeviceInfo
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
-}
\ No newline at end of file
+	Token         string `json:"token,omitempty"`
+	User          *User  `json:"user,omitempty"`
+	MFARequired   bool   `json:"mfa_required,omitempty"`
+	MFAToken      string `json:"mfa_token,omitempty"`
+	ResetRequired bool   `json:"password_reset_required,omitempty"`
+	ResetToken    string `json:"reset_token,omitempty"`
+}
+
+type PasswordResetRequest struct {
+	ResetToken  string `json:"reset_token"`
+	NewPassword string `json:"new_password"`
+	DeviceInfo
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	DeviceInfo
+}
+
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+type TOTPConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TOTPDisableRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type UserSession struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"-"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
@@ -1,22 +1,135 @@
 package models
 
+import "encoding/json"
+
 type MessageType string
 
 const (
 	MessageTypeMessage        MessageType = "message"
+	MessageTypeMessageEdited  MessageType = "message_edited"
+	MessageTypeReaction       MessageType = "reaction"
 	MessageTypeUserJoined     MessageType = "user_joined"
 	MessageTypeUserLeft       MessageType = "user_left"
 	MessageTypeOnlineUsers    MessageType = "online_users"
 	MessageTypePresenceUpdate MessageType = "presence_update"
+	MessageTypeError          MessageType = "error"
+	MessageTypeReconnect      MessageType = "reconnect"
+	MessageTypeTypingStarted  MessageType = "typing_started"
+	MessageTypeTypingStopped  MessageType = "typing_stopped"
+	MessageTypeTyping         MessageType = "typing"
+	MessageTypeReply          MessageType = "reply"
+	MessageTypePong           MessageType = "pong"
+	MessageTypeAck            MessageType = "ack"
+	MessageTypeNack           MessageType = "nack"
+	MessageTypeResyncRequired MessageType = "resync_required"
+	MessageTypeUnsubscribed   MessageType = "unsubscribed"
 )
 
+const (
+	ErrorCodeRateLimited        = "rate_limited"
+	ErrorCodeBadRequest         = "bad_request"
+	ErrorCodeUnsupportedVersion = "unsupported_version"
+	ErrorCodeUnknownType        = "unknown_type"
+	ErrorCodeNotFound           = "not_found"
+	ErrorCodeNotSupported       = "not_supported"
+	ErrorCodeForbidden          = "forbidden"
+	ErrorCodeNotSubscribed      = "not_subscribed"
+	ErrorCodeSubscriptionLimit  = "subscription_limit"
+	ErrorCodeInternal           = "internal_error"
+)
+
+// WebSocketMessage is a frame sent to clients. Room events carry RoomID so a
+// multiplexed connection can tell its rooms apart.
 type WebSocketMessage struct {
 	Type        MessageType   `json:"type"`
+	RoomID      int           `json:"room_id,omitempty"`
+	RequestID   string        `json:"request_id,omitempty"`
+	MessageID   int           `json:"message_id,omitempty"`
+	ClientID    string        `json:"client_id,omitempty"`
+	Seq         int64         `json:"seq,omitempty"`
 	Text        string        `json:"text,omitempty"`
 	Sender      string        `json:"sender,omitempty"`
+	UserID      int           `json:"user_id,omitempty"`
 	Username    string        `json:"username,omitempty"`
+	Emoji       string        `json:"emoji,omitempty"`
+	Removed     bool          `json:"removed,omitempty"`
 	Timestamp   string        `json:"timestamp,omitempty"`
 	Users       []string      `json:"users,omitempty"`
 	ActiveUsers []*ActiveUser `json:"active_users,omitempty"`
 	UserCount   int           `json:"user_count,omitempty"`
-}
\ No newline at end of file
+	Code        string        `json:"code,omitempty"`
+	RetryAfter  int           `json:"retry_after_ms,omitempty"`
+}
+
+// ProtocolVersion is the newest client frame envelope the server understands.
+const ProtocolVersion = 1
+
+type ClientFrameType string
+
+const (
+	ClientFrameSendMessage ClientFrameType = "send_message"
+	ClientFrameEdit        ClientFrameType = "edit"
+	ClientFrameReact       ClientFrameType = "react"
+	ClientFrameTyping      ClientFrameType = "typing"
+	ClientFrameAck         ClientFrameType = "ack"
+	ClientFramePing        ClientFrameType = "ping"
+	ClientFrameSubscribe   ClientFrameType = "subscribe"
+	ClientFrameUnsubscribe ClientFrameType = "unsubscribe"
+)
+
+// ClientFrame is the envelope of every structured frame a client sends. ID is
+// chosen by the client and echoed in the reply so it can match them up. Room
+// selects which subscribed room a room request applies to on a multiplexed
+// connection.
+type ClientFrame struct {
+	Version int             `json:"v"`
+	Type    ClientFrameType `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Room    int             `json:"room_id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// SendMessageRequest posts a chat message. ClientID is generated by the client
+// and makes resending after a lost ack or a reconnect safe.
+type SendMessageRequest struct {
+	ClientID string `json:"client_id,omitempty"`
+	Text     string `json:"text"`
+}
+
+type EditRequest struct {
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text"`
+}
+
+type ReactRequest struct {
+	MessageID int    `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	Remove    bool   `json:"remove,omitempty"`
+}
+
+type TypingRequest struct {
+	Active bool `json:"active"`
+}
+
+type AckRequest struct {
+	MessageID int `json:"message_id"`
+}
+
+// SubscribeRequest joins a room. LastSeq resumes after the last message the
+// client saw instead of sending the recent history.
+type SubscribeRequest struct {
+	RoomID  int    `json:"room_id"`
+	LastSeq *int64 `json:"last_seq,omitempty"`
+}
+
+type UnsubscribeRequest struct {
+	RoomID int `json:"room_id"`
+}
+
+// PollResponse is the answer to a long poll. PollID continues the session on
+// the next poll; once Closed is set the client must start a new one.
+type PollResponse struct {
+	PollID string            `json:"poll_id"`
+	Events []json.RawMessage `json:"events"`
+	Closed bool              `json:"closed,omitempty"`
+}
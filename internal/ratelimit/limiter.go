@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"chat-app/internal/config"
+	"chat-app/pkg/logger"
+)
+
+// LimitedError is returned when a caller must wait before trying again.
+type LimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("too many attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// Limiter throttles login attempts per IP with a sliding window and per
+// account with progressive delays followed by a temporary lockout.
+type Limiter struct {
+	store Store
+	cfg   atomic.Pointer[config.RateLimitConfig]
+}
+
+func NewLimiter(store Store, cfg config.RateLimitConfig) *Limiter {
+	l := &Limiter{store: store}
+	l.cfg.Store(&cfg)
+	return l
+}
+
+// SetConfig replaces the limits used for subsequent attempts.
+func (l *Limiter) SetConfig(cfg config.RateLimitConfig) {
+	l.cfg.Store(&cfg)
+}
+
+// Allow reports a *LimitedError if either the IP or the account is currently
+// blocked. Only failed attempts count towards the IP's limit, so users sharing
+// an address don't lock each other out by logging in.
+func (l *Limiter) Allow(ctx context.Context, ip, account string) error {
+	cfg := l.cfg.Load()
+	now := time.Now()
+	ipKey := ipKey(ip)
+	accountKey := accountKey(account)
+
+	for _, key := range []string{ipKey, accountKey} {
+		until, err := l.store.GetLockout(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to check lockout: %w", err)
+		}
+		if until.After(now) {
+			return &LimitedError{RetryAfter: until.Sub(now)}
+		}
+	}
+
+	count, oldest, err := l.store.CountAttempts(ctx, ipKey, now.Add(-cfg.Window))
+	if err != nil {
+		return fmt.Errorf("failed to count attempts: %w", err)
+	}
+	if count >= cfg.MaxAttemptsPerIP {
+		return &LimitedError{RetryAfter: oldest.Add(cfg.Window).Sub(now)}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt against the IP and the account, and
+// blocks the account for an exponentially growing delay, or the full lockout
+// once the threshold is hit.
+func (l *Limiter) RecordFailure(ctx context.Context, ip, account string) {
+	cfg := l.cfg.Load()
+	now := time.Now()
+	key := accountKey(account)
+
+	if err := l.store.AddAttempt(ctx, ipKey(ip), now); err != nil {
+		logger.ErrorContext(ctx, "Error recording failed attempt: %v", err)
+	}
+
+	if err := l.store.AddAttempt(ctx, key, now); err != nil {
+		logger.ErrorContext(ctx, "Error recording failed attempt: %v", err)
+		return
+	}
+
+	failures, _, err := l.store.CountAttempts(ctx, key, now.Add(-cfg.Window))
+	if err != nil {
+		logger.ErrorContext(ctx, "Error counting failed attempts: %v", err)
+		return
+	}
+
+	var delay time.Duration
+	switch {
+	case failures >= cfg.LockoutThreshold:
+		delay = cfg.LockoutDuration
+		logger.InfoContext(ctx, "Account %s locked for %s after %d failed attempts", account, delay, failures)
+	case failures >= cfg.DelayThreshold:
+		delay = cfg.BaseDelay << (failures - cfg.DelayThreshold)
+		if delay <= 0 || delay > cfg.MaxDelay {
+			delay = cfg.MaxDelay
+		}
+	default:
+		return
+	}
+
+	if err := l.store.SetLockout(ctx, key, now.Add(delay)); err != nil {
+		logger.ErrorContext(ctx, "Error setting lockout: %v", err)
+	}
+}
+
+// RecordSuccess clears the account's failure history.
+func (l *Limiter) RecordSuccess(ctx context.Context, account string) {
+	if err := l.store.ClearAttempts(ctx, accountKey(account)); err != nil {
+		logger.ErrorContext(ctx, "Error clearing attempts: %v", err)
+	}
+}
+
+func (l *Limiter) StartCleanupRoutine() {
+	for {
+		window := l.cfg.Load().Window
+		time.Sleep(window)
+
+		if err := l.store.PruneAttempts(context.Background(), time.Now().Add(-window)); err != nil {
+			logger.Error("Error pruning login attempts: %v", err)
+		}
+	}
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func accountKey(account string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(account))
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store persists attempt history and lockouts so limits can be shared or
+// survive restarts.
+type Store interface {
+	AddAttempt(ctx context.Context, key string, at time.Time) error
+	CountAttempts(ctx context.Context, key string, since time.Time) (int, time.Time, error)
+	ClearAttempts(ctx context.Context, key string) error
+	SetLockout(ctx context.Context, key string, until time.Time) error
+	GetLockout(ctx context.Context, key string) (time.Time, error)
+	PruneAttempts(ctx context.Context, before time.Time) error
+}
+
+type MemoryStore struct {
+	attempts map[string][]time.Time
+	lockouts map[string]time.Time
+	mutex    sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts: make(map[string][]time.Time),
+		lockouts: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) AddAttempt(ctx context.Context, key string, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.attempts[key] = append(s.attempts[key], at)
+	return nil
+}
+
+// CountAttempts returns the number of attempts since the given time and the
+// time of the oldest one in that window.
+func (s *MemoryStore) CountAttempts(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var count int
+	var oldest time.Time
+	for _, at := range s.attempts[key] {
+		if at.Before(since) {
+			continue
+		}
+		if count == 0 || at.Before(oldest) {
+			oldest = at
+		}
+		count++
+	}
+	return count, oldest, nil
+}
+
+func (s *MemoryStore) ClearAttempts(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.attempts, key)
+	delete(s.lockouts, key)
+	return nil
+}
+
+func (s *MemoryStore) SetLockout(ctx context.Context, key string, until time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if current, ok := s.lockouts[key]; !ok || until.After(current) {
+		s.lockouts[key] = until
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetLockout(ctx context.Context, key string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.lockouts[key], nil
+}
+
+func (s *MemoryStore) PruneAttempts(ctx context.Context, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, attempts := range s.attempts {
+		kept := attempts[:0]
+		for _, at := range attempts {
+			if !at.Before(before) {
+				kept = append(kept, at)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.attempts, key)
+		} else {
+			s.attempts[key] = kept
+		}
+	}
+
+	now := time.Now()
+	for key, until := range s.lockouts {
+		if until.Before(now) {
+			delete(s.lockouts, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"chat-app/internal/audit"
+	"chat-app/internal/database"
+	"chat-app/internal/models"
+)
+
+type AdminService struct {
+	db    database.Database
+	audit *audit.Recorder
+}
+
+func NewAdminService(db database.Database, auditRecorder *audit.Recorder) *AdminService {
+	return &AdminService{
+		db:    db,
+		audit: auditRecorder,
+	}
+}
+
+func (s *AdminService) ListUsers(ctx context.Context, search string, limit, offset int) ([]*models.User, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return s.db.ListUsers(ctx, search, limit, offset)
+}
+
+func (s *AdminService) DeactivateUser(ctx context.Context, admin *models.User, userID int) error {
+	if admin.ID == userID {
+		return fmt.Errorf("cannot deactivate your own account")
+	}
+
+	if err := s.db.SetUserActive(ctx, userID, false); err != nil {
+		return err
+	}
+
+	// Existing tokens must stop working immediately
+	if err := s.db.RevokeAllUserSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	s.recordUserAction(ctx, admin, audit.ActionUserDeactivated, userID)
+	return nil
+}
+
+func (s *AdminService) ReactivateUser(ctx context.Context, admin *models.User, userID int) error {
+	if err := s.db.SetUserActive(ctx, userID, true); err != nil {
+		return err
+	}
+
+	s.recordUserAction(ctx, admin, audit.ActionUserReactivated, userID)
+	return nil
+}
+
+func (s *AdminService) ForcePasswordReset(ctx context.Context, admin *models.User, userID int) error {
+	if err := s.db.RequirePasswordReset(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := s.db.RevokeAllUserSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	s.recordUserAction(ctx, admin, audit.ActionForcePasswordReset, userID)
+	return nil
+}
+
+func (s *AdminService) SetAdmin(ctx context.Context, admin *models.User, userID int, isAdmin bool) error {
+	if admin.ID == userID && !isAdmin {
+		return fmt.Errorf("cannot revoke your own admin role")
+	}
+
+	if err := s.db.SetUserAdmin(ctx, userID, isAdmin); err != nil {
+		return err
+	}
+
+	action := audit.ActionRoleGranted
+	if !isAdmin {
+		action = audit.ActionRoleRevoked
+	}
+	s.recordUserAction(ctx, admin, action, userID)
+	return nil
+}
+
+func (s *AdminService) DeleteRoom(ctx context.Context, admin *models.User, roomID int) error {
+	if err := s.db.ForceDeleteRoom(ctx, roomID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    admin.ID,
+		ActorName:  admin.Username,
+		Action:     audit.ActionAdminRoomDelete,
+		TargetType: audit.TargetRoom,
+		TargetID:   audit.ID(roomID),
+	})
+	return nil
+}
+
+func (s *AdminService) GetStats(ctx context.Context) (*models.ServerStats, error) {
+	return s.db.GetServerStats(ctx)
+}
+
+func (s *AdminService) QueryAuditLog(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	return s.audit.Query(ctx, filter)
+}
+
+func (s *AdminService) ExportAuditLog(ctx context.Context, filter models.AuditFilter, fn func(*models.AuditEvent) error) error {
+	return s.audit.Export(ctx, filter, fn)
+}
+
+func (s *AdminService) recordUserAction(ctx context.Context, admin *models.User, action string, userID int) {
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    admin.ID,
+		ActorName:  admin.Username,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   audit.ID(userID),
+	})
+}
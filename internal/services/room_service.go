@@ -4,24 +4,60 @@ import (
 	"context"
 	"fmt"
 
+	"chat-app/internal/audit"
 	"chat-app/internal/database"
 	"chat-app/internal/models"
 )
 
 type RoomService struct {
-	db database.Database
+	db    database.Database
+	audit *audit.Recorder
 }
 
-func NewRoomService(db database.Database) *RoomService {
-	return &RoomService{db: db}
+func NewRoomService(db database.Database, auditRecorder *audit.Recorder) *RoomService {
+	return &RoomService{
+		db:    db,
+		audit: auditRecorder,
+	}
 }
 
+// Rooms may override the flood limits up to these caps.
+const (
+	maxRoomMessageRate  = 100
+	maxRoomMessageBurst = 1000
+)
+
 func (s *RoomService) CreateRoom(ctx context.Context, req *models.CreateRoomRequest, ownerID int) (*models.Room, error) {
 	if req.Name == "" {
 		return nil, fmt.Errorf("room name is required")
 	}
+	if rate := req.MessageRate; rate != nil && (*rate <= 0 || *rate > maxRoomMessageRate) {
+		return nil, fmt.Errorf("message rate must be above 0 and at most %d per second", maxRoomMessageRate)
+	}
+	if burst := req.MessageBurst; burst != nil && (*burst <= 0 || *burst > maxRoomMessageBurst) {
+		return nil, fmt.Errorf("message burst must be between 1 and %d", maxRoomMessageBurst)
+	}
+	if policy := req.SlowConsumerPolicy; policy != nil {
+		switch *policy {
+		case models.SlowConsumerDisconnect, models.SlowConsumerDropOldest, models.SlowConsumerCoalesce:
+		default:
+			return nil, fmt.Errorf("invalid slow consumer policy %q", *policy)
+		}
+	}
+
+	room, err := s.db.CreateRoom(ctx, req, ownerID)
+	if err != nil {
+		return nil, err
+	}
 
-	return s.db.CreateRoom(ctx, req, ownerID)
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    ownerID,
+		Action:     audit.ActionRoomCreate,
+		TargetType: audit.TargetRoom,
+		TargetID:   audit.ID(room.ID),
+		Metadata:   map[string]interface{}{"name": room.Name, "is_public": room.IsPublic},
+	})
+	return room, nil
 }
 
 func (s *RoomService) ListUserRooms(ctx context.Context, userID int) ([]*models.Room, error) {
@@ -33,7 +69,17 @@ func (s *RoomService) GetRoom(ctx context.Context, roomID int) (*models.Room, er
 }
 
 func (s *RoomService) DeleteRoom(ctx context.Context, roomID, ownerID int) error {
-	return s.db.DeleteRoom(ctx, roomID, ownerID)
+	if err := s.db.DeleteRoom(ctx, roomID, ownerID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    ownerID,
+		Action:     audit.ActionRoomDelete,
+		TargetType: audit.TargetRoom,
+		TargetID:   audit.ID(roomID),
+	})
+	return nil
 }
 
 func (s *RoomService) InviteUser(ctx context.Context, roomID, inviterID int, email string) error {
@@ -61,7 +107,25 @@ func (s *RoomService) InviteUser(ctx context.Context, roomID, inviterID int, ema
 	}
 
 	// Add membership
-	return s.db.AddMembership(ctx, user.ID, roomID)
+	if err := s.db.AddMembership(ctx, user.ID, roomID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    inviterID,
+		Action:     audit.ActionRoomInvite,
+		TargetType: audit.TargetRoom,
+		TargetID:   audit.ID(roomID),
+		Metadata:   map[string]interface{}{"invitee_id": user.ID},
+	})
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    inviterID,
+		Action:     audit.ActionMemberJoined,
+		TargetType: audit.TargetUser,
+		TargetID:   audit.ID(user.ID),
+		Metadata:   map[string]interface{}{"room_id": roomID},
+	})
+	return nil
 }
 
 func (s *RoomService) LeaveRoom(ctx context.Context, userID, roomID int) error {
@@ -73,7 +137,18 @@ func (s *RoomService) LeaveRoom(ctx context.Context, userID, roomID int) error {
 		return fmt.Errorf("not a member of this room")
 	}
 
-	return s.db.RemoveMembership(ctx, userID, roomID)
+	if err := s.db.RemoveMembership(ctx, userID, roomID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionMemberLeft,
+		TargetType: audit.TargetUser,
+		TargetID:   audit.ID(userID),
+		Metadata:   map[string]interface{}{"room_id": roomID},
+	})
+	return nil
 }
 
 func (s *RoomService) GetRoomMembers(ctx context.Context, roomID, userID int) ([]*models.Member, error) {
@@ -110,6 +185,20 @@ func (s *RoomService) GetActiveUsers(ctx context.Context, roomID, userID int) ([
 	return s.db.GetActiveUsersInRoom(ctx, roomID)
 }
 
+// GetMessages returns up to limit of the room's messages numbered after
+// afterSeq, for clients catching up on what they missed.
+func (s *RoomService) GetMessages(ctx context.Context, roomID, userID int, afterSeq int64, limit int) ([]*models.Message, error) {
+	canAccess, err := s.CanUserAccessRoom(ctx, userID, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("room not found")
+	}
+	if !canAccess {
+		return nil, fmt.Errorf("forbidden")
+	}
+
+	return s.db.LoadMessagesAfter(ctx, roomID, afterSeq, limit)
+}
+
 func (s *RoomService) CanUserAccessRoom(ctx context.Context, userID, roomID int) (bool, error) {
 	room, err := s.db.GetRoomByID(ctx, roomID)
 	if err != nil {
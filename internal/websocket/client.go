@@ -3,70 +3,304 @@ package websocket
 import (
 	"context"
 	"crypto/rand"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"chat-app/internal/config"
 	"chat-app/internal/database"
+	"chat-app/internal/metrics"
 	"chat-app/internal/models"
+	"chat-app/internal/tracing"
 	"chat-app/pkg/logger"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrTooManySubscriptions is returned when a connection subscribes to more
+// rooms than websocket.max_subscriptions allows.
+var ErrTooManySubscriptions = errors.New("too many subscriptions")
+
+// RoomAccess decides whether a user may join a room.
+type RoomAccess interface {
+	CanUserAccessRoom(ctx context.Context, userID, roomID int) (bool, error)
+}
+
+// Client is one connection receiving room frames. It is registered with the
+// hub of every room it subscribes to; a legacy connection subscribes to a
+// single room when it connects, while a multiplexed one picks its rooms with
+// subscribe frames. WebSocket clients also send requests over conn, while SSE
+// and long-poll clients only receive.
 type Client struct {
-	hub       *Hub
-	conn      *websocket.Conn
-	send      chan []byte
-	userID    int
-	username  string
-	roomID    int
-	sessionID string
-	db        database.Database
+	ctx           context.Context
+	cfg           config.WebSocketConfig
+	flood         config.FloodControlConfig
+	manager       *Manager
+	access        RoomAccess
+	conn          *websocket.Conn
+	transport     transport
+	kind          string
+	codec         *codec
+	queue         *sendQueue
+	wake          chan struct{}
+	userID        int
+	username      string
+	sessionID     string
+	authSessionID string
+	db            database.Database
+	limiter       *tokenBucket
+	violations    int
+	lastViolation time.Time
+	multiplexed   bool
+	// legacyHistory sends the recent history in the format clients predating
+	// sequence numbers expect.
+	legacyHistory bool
+
+	mutex sync.Mutex
+	rooms map[int]*subscription
+	// defaultRoom is the room of a legacy connection, which requests apply to
+	// without naming it.
+	defaultRoom int
+
+	// done is closed once, by close, and tells WritePump to flush and send
+	// the close frame. Nothing else ever closes a client's channels.
+	closeOnce   sync.Once
+	done        chan struct{}
+	closeCode   int
+	closeReason string
+}
+
+// subscription is a client's membership of one room. While the room's missed
+// messages are loaded, live frames for it wait in pending so they are written
+// after the replay; WritePump flushes them once replaying is cleared.
+type subscription struct {
+	hub         *Hub
+	roomID      int
+	limiter     *tokenBucket
+	replaying   bool
+	pending     []Frame
+	replayedSeq int64
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn, userID int, username string, roomID int, db database.Database) (*Client, error) {
+// NewClient creates a client for an upgraded connection. ctx carries the
+// upgrade request's values, such as its request ID, for the session lifetime.
+// Multiplexed clients tag every request with its room and start without any.
+// Frames are encoded in the format of the negotiated subprotocol.
+func NewClient(ctx context.Context, manager *Manager, conn *websocket.Conn, access RoomAccess, userID int, username string, authSessionID string, db database.Database, multiplexed bool) (*Client, error) {
+	cfg := manager.config()
+	codec := codecFor(conn.Subprotocol())
+	t := &wsTransport{ctx: ctx, conn: conn, messageType: codec.messageType, writeTimeout: cfg.WriteTimeout}
+	if deflate, ok := conn.NetConn().(*deflateConn); ok {
+		conn.SetCompressionLevel(cfg.CompressionLevel)
+		t.deflate = deflate
+		t.compressMin = cfg.CompressionMinSize
+	}
+	client, err := newClient(ctx, manager, t, "websocket", userID, username, authSessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	client.codec = codec
+	client.conn = conn
+	client.access = access
+	client.db = db
+	client.multiplexed = multiplexed
+	client.legacyHistory = !multiplexed
+	return client, nil
+}
+
+func newClient(ctx context.Context, manager *Manager, t transport, kind string, userID int, username string, authSessionID string) (*Client, error) {
 	sessionID, err := generateSessionID()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate session ID: %w", err)
 	}
 
-	client := &Client{
-		hub:       hub,
-		conn:      conn,
-		send:      make(chan []byte, 256),
-		userID:    userID,
-		username:  username,
-		roomID:    roomID,
-		sessionID: sessionID,
-		db:        db,
+	cfg := manager.config()
+	flood := manager.floodConfig()
+	return &Client{
+		ctx:           ctx,
+		cfg:           cfg,
+		flood:         flood,
+		manager:       manager,
+		transport:     t,
+		kind:          kind,
+		codec:         jsonCodec,
+		queue:         newSendQueue(cfg.SendBuffer),
+		wake:          make(chan struct{}, 1),
+		userID:        userID,
+		username:      username,
+		sessionID:     sessionID,
+		authSessionID: authSessionID,
+		db:            manager.db,
+		limiter:       newTokenBucket(flood.ClientRate, flood.ClientBurst),
+		rooms:         make(map[int]*subscription),
+		done:          make(chan struct{}),
+	}, nil
+}
+
+// Subscribe joins the client to a room's hub and queues the messages it
+// missed ahead of the room's live frames: those numbered after lastSeq, or
+// the recent history when lastSeq is negative. Access must already have been
+// checked.
+func (c *Client) Subscribe(roomID int, lastSeq int64) error {
+	c.mutex.Lock()
+	_, subscribed := c.rooms[roomID]
+	full := len(c.rooms) >= c.cfg.MaxSubscriptions
+	c.mutex.Unlock()
+	if subscribed {
+		return nil
+	}
+	if full {
+		return ErrTooManySubscriptions
+	}
+
+	// Register before loading what was missed, so no message falls between
+	// the two
+	var sub *subscription
+	for {
+		hub, err := c.manager.GetHubForRoom(c.ctx, roomID)
+		if err != nil {
+			c.mutex.Lock()
+			delete(c.rooms, roomID)
+			c.mutex.Unlock()
+			return err
+		}
+
+		sub = &subscription{hub: hub, roomID: roomID, limiter: hub.flood.newClientBucket(), replaying: true}
+		c.mutex.Lock()
+		c.rooms[roomID] = sub
+		if !c.multiplexed && c.defaultRoom == 0 {
+			c.defaultRoom = roomID
+		}
+		c.mutex.Unlock()
+
+		if hub.register(c) {
+			break
+		}
+		// The hub stopped for being idle in the meantime; the next lookup
+		// starts a new one
 	}
 
-	// Create active session in database
-	ctx := context.Background()
-	if err := db.CreateActiveSession(ctx, userID, roomID, sessionID); err != nil {
-		logger.Error("Error creating active session: %v", err)
-		return nil, fmt.Errorf("error creating session: %w", err)
+	// The session is recorded only once the hub counts the connection, and
+	// removed before it stops, so presence syncs never mistake it for one on
+	// another instance
+	if err := c.db.CreateActiveSession(c.ctx, c.userID, roomID, c.sessionID); err != nil {
+		logger.ErrorContext(c.ctx, "Error creating active session: %v", err)
+		c.unsubscribe(roomID)
+		return fmt.Errorf("error creating session: %w", err)
 	}
 
-	return client, nil
+	frames, replayedSeq := c.loadMissed(roomID, lastSeq)
+
+	c.mutex.Lock()
+	live := sub.pending
+	sub.pending = frames
+	for _, frame := range live {
+		if frame.Seq == 0 || frame.Seq > replayedSeq {
+			sub.pending = append(sub.pending, frame)
+		}
+	}
+	sub.replayedSeq = replayedSeq
+	sub.replaying = false
+	c.mutex.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// unsubscribe leaves the room and reports whether the client was in it.
+func (c *Client) unsubscribe(roomID int) bool {
+	c.mutex.Lock()
+	sub, ok := c.rooms[roomID]
+	delete(c.rooms, roomID)
+	c.mutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	c.removeActiveSession(roomID)
+	sub.hub.unregister(c)
+	return true
+}
+
+func (c *Client) unsubscribeAll() {
+	c.mutex.Lock()
+	roomIDs := make([]int, 0, len(c.rooms))
+	for roomID := range c.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	c.mutex.Unlock()
+
+	for _, roomID := range roomIDs {
+		c.unsubscribe(roomID)
+	}
+}
+
+func (c *Client) removeActiveSession(roomID int) {
+	if err := c.db.RemoveActiveSession(c.ctx, c.userID, roomID, c.sessionID); err != nil {
+		logger.ErrorContext(c.ctx, "Error removing active session: %v", err)
+	}
+}
+
+// subscription returns the room a request applies to, or nil if the client
+// isn't subscribed to it. Legacy clients may leave the room out.
+func (c *Client) subscription(roomID int) *subscription {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if roomID == 0 && !c.multiplexed {
+		roomID = c.defaultRoom
+	}
+	return c.rooms[roomID]
+}
+
+// evict removes the client from a room it may no longer be in. A legacy
+// connection only serves that room and is closed; a multiplexed one is told
+// it was unsubscribed and keeps its other rooms. It does not block, so hubs
+// can call it.
+func (c *Client) evict(roomID int, code int, reason string) {
+	if !c.multiplexed {
+		go c.disconnect(code, reason)
+		return
+	}
+
+	go func() {
+		if !c.unsubscribe(roomID) {
+			return
+		}
+		c.sendDirect(c.ctx, &models.WebSocketMessage{
+			Type:      models.MessageTypeUnsubscribed,
+			RoomID:    roomID,
+			Text:      reason,
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+	}()
 }
 
 func (c *Client) ReadPump() {
+	metrics.WSConnections.Inc()
+	metrics.WSConnectionsTotal.Inc()
 	defer func() {
-		// Remove active session from database
-		ctx := context.Background()
-		if err := c.db.RemoveActiveSession(ctx, c.userID, c.roomID, c.sessionID); err != nil {
-			logger.Error("Error removing active session: %v", err)
-		}
-		c.hub.Unregister <- c
+		c.unsubscribeAll()
+		c.close(websocket.CloseNormalClosure, "")
 		c.conn.Close()
+		metrics.WSConnections.Dec()
 	}()
 
+	// Reject oversized frames before they are read into memory
+	c.conn.SetReadLimit(c.flood.MaxMessageSize)
+
 	// Set read deadline and pong handler for connection health
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.conn.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.conn.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
 		return nil
 	})
 
@@ -74,101 +308,236 @@ func (c *Client) ReadPump() {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				logger.Error("WebSocket error: %v", err)
+				logger.ErrorContext(c.ctx, "WebSocket error: %v", err)
 			}
 			break
 		}
+		metrics.WSMessagesReceived.Inc()
 
-		// Update session activity
-		ctx := context.Background()
-		if err := c.db.UpdateSessionActivity(ctx, c.userID, c.roomID, c.sessionID); err != nil {
-			logger.Error("Error updating session activity: %v", err)
-		}
-
-		// Save message to database
-		if err := c.db.SaveMessage(ctx, c.userID, c.roomID, string(message)); err != nil {
-			logger.Error("Error saving message: %v", err)
-		}
-
-		// Create structured message for broadcast
-		msgData := models.WebSocketMessage{
-			Type:      models.MessageTypeMessage,
-			Text:      string(message),
-			Sender:    c.username,
-			Timestamp: time.Now().Format(time.RFC3339),
+		frame, err := c.codec.decodeFrame(message)
+		if err != nil {
+			c.sendError(&models.ClientFrame{}, models.ErrorCodeBadRequest, "malformed frame", 0)
+			continue
 		}
-
-		if data, err := json.Marshal(msgData); err == nil {
-			c.hub.Broadcast <- data
-		} else {
-			logger.Error("Error marshaling message: %v", err)
-			// Fallback to simple text broadcast
-			c.hub.Broadcast <- message
+		if !c.dispatch(frame) {
+			break
 		}
 	}
 }
 
+// WritePump delivers the client's frames through its transport until the
+// client is closed or the peer goes away.
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(c.cfg.PingInterval)
 	defer func() {
 		ticker.Stop()
-		c.conn.Close()
+		c.transport.release()
 	}()
 
 	for {
 		select {
-		case msg, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-c.queue.ready:
+			for _, frame := range c.queue.take() {
+				if err := c.deliver(frame); err != nil {
+					logger.ErrorContext(c.ctx, "Write error: %v", err)
+					return
+				}
+			}
+
+		case <-c.wake:
+			if err := c.flushReplayed(); err != nil {
+				logger.ErrorContext(c.ctx, "Write error: %v", err)
 				return
 			}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				logger.Error("Write error: %v", err)
+		case <-c.done:
+			// Flush what was queued before the close, then say goodbye
+			for _, frame := range c.queue.take() {
+				if err := c.deliver(frame); err != nil {
+					return
+				}
+			}
+			if err := c.flushReplayed(); err != nil {
 				return
 			}
+			c.transport.writeClose(c.closeCode, c.closeReason)
+			return
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.transport.ping(); err != nil {
 				return
 			}
 		}
 	}
 }
 
-func (c *Client) SendRecentMessages() {
-	ctx := context.Background()
-	messages, err := c.db.LoadRecentMessages(ctx, c.roomID, 10)
+// deliver writes a queued frame. Room frames are dropped once the client left
+// the room or was already replayed the message, and held back while the
+// room's replay is still pending.
+func (c *Client) deliver(frame Frame) error {
+	if frame.Room != 0 {
+		c.mutex.Lock()
+		sub := c.rooms[frame.Room]
+		if sub == nil || (frame.Seq != 0 && frame.Seq <= sub.replayedSeq) {
+			c.mutex.Unlock()
+			return nil
+		}
+		if sub.replaying || len(sub.pending) > 0 {
+			sub.pending = append(sub.pending, frame)
+			overflow := sub.replaying && len(sub.pending) > c.cfg.SendBuffer
+			c.mutex.Unlock()
+			if overflow {
+				metrics.WSSlowClientsDropped.Inc()
+				c.close(websocket.CloseTryAgainLater, "client too slow")
+			}
+			return nil
+		}
+		c.mutex.Unlock()
+	}
+	return c.write(frame)
+}
+
+// flushReplayed writes the rooms whose replay finished, followed by the live
+// frames held back meanwhile.
+func (c *Client) flushReplayed() error {
+	var frames []Frame
+	c.mutex.Lock()
+	for _, sub := range c.rooms {
+		if !sub.replaying && len(sub.pending) > 0 {
+			frames = append(frames, sub.pending...)
+			sub.pending = nil
+		}
+	}
+	c.mutex.Unlock()
+
+	for _, frame := range frames {
+		if err := c.write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write sends a frame through the transport. Only WritePump may call it.
+func (c *Client) write(frame Frame) error {
+	_, span := tracing.Tracer().Start(frame.Ctx, "websocket.write",
+		trace.WithAttributes(
+			attribute.Int("user.id", c.userID),
+			attribute.String("transport", c.kind),
+		),
+	)
+	defer span.End()
+
+	if err := c.transport.writeFrame(frame); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	metrics.WSMessagesSent.Inc()
+	if !frame.queued.IsZero() {
+		metrics.WSSendLag.Observe(time.Since(frame.queued).Seconds())
+	}
+	return nil
+}
+
+// loadMissed builds the frames a new subscription starts with and the highest
+// sequence number among them. Legacy clients not resuming get the history in
+// the format they expect.
+func (c *Client) loadMissed(roomID int, lastSeq int64) ([]Frame, int64) {
+	resume := lastSeq >= 0
+	var messages []*models.Message
+	var err error
+	if resume {
+		messages, err = c.db.LoadMessagesAfter(c.ctx, roomID, lastSeq, c.cfg.ReplayLimit+1)
+	} else {
+		messages, err = c.db.LoadRecentMessages(c.ctx, roomID, c.cfg.HistoryLimit)
+	}
 	if err != nil {
-		logger.Error("Error loading recent messages: %v", err)
-		return
+		logger.ErrorContext(c.ctx, "Error loading missed messages: %v", err)
+		return nil, 0
+	}
+
+	if resume && len(messages) > c.cfg.ReplayLimit {
+		metrics.WSReplays.WithLabelValues("too_far_behind").Inc()
+		frame := c.roomFrame(roomID, &models.WebSocketMessage{
+			Type:      models.MessageTypeResyncRequired,
+			RoomID:    roomID,
+			Seq:       lastSeq,
+			Text:      "too far behind, fetch missed messages via REST",
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		return []Frame{frame}, 0
 	}
 
+	frames := make([]Frame, 0, len(messages))
+	var replayedSeq int64
 	for _, msg := range messages {
-		historyMsg := models.WebSocketMessage{
+		replayed := &models.WebSocketMessage{
 			Type:      models.MessageTypeMessage,
-			Text:      fmt.Sprintf("%s: %s", msg.Username, msg.Content),
-			Sender:    "system",
+			RoomID:    roomID,
+			MessageID: msg.ID,
+			ClientID:  msg.ClientID,
+			Seq:       msg.Seq,
+			Text:      msg.Content,
+			Sender:    msg.Username,
 			Timestamp: msg.CreatedAt.Format(time.RFC3339),
 		}
-
-		if data, err := json.Marshal(historyMsg); err == nil {
-			select {
-			case c.send <- data:
-			default:
-				close(c.send)
-				return
-			}
+		// Plain history keeps the format clients without last_seq expect
+		if !resume && c.legacyHistory {
+			replayed.Text = fmt.Sprintf("%s: %s", msg.Username, msg.Content)
+			replayed.Sender = "system"
 		}
+
+		frames = append(frames, c.roomFrame(roomID, replayed))
+		replayedSeq = max(replayedSeq, msg.Seq)
+	}
+
+	if resume {
+		metrics.WSReplays.WithLabelValues("replayed").Inc()
+	}
+	return frames, replayedSeq
+}
+
+func (c *Client) roomFrame(roomID int, msg *models.WebSocketMessage) Frame {
+	data, err := c.codec.encode(msg)
+	if err != nil {
+		logger.ErrorContext(c.ctx, "Error marshaling %s frame: %v", msg.Type, err)
+	}
+	return Frame{Ctx: c.ctx, Data: data, Seq: msg.Seq, Room: roomID}
+}
+
+// close makes WritePump flush the queued frames and then close the connection
+// with the given code. Only the first call has any effect.
+func (c *Client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+// closed reports whether the client is closing, so hubs stop queueing to it.
+func (c *Client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
 	}
 }
 
+// disconnect drops the peer right away after telling it why, where the
+// transport allows. Whoever runs the client then unsubscribes it from its
+// rooms.
+func (c *Client) disconnect(code int, reason string) {
+	c.transport.abort(code, reason)
+	c.close(code, reason)
+}
+
 func generateSessionID() (string, error) {
 	bytes := make([]byte, 16)
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("%x", bytes), nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"chat-app/internal/metrics"
+	"chat-app/internal/models"
+	"chat-app/pkg/logger"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// codec is a wire format a WebSocket client negotiates with its subprotocol.
+// Hubs and the cluster bus pass frames around as JSON, which is converted to
+// the client's format on the way out.
+type codec struct {
+	name        string
+	subprotocol string
+	messageType int
+	encode      func(msg *models.WebSocketMessage) ([]byte, error)
+	decodeFrame func(message []byte) (*models.ClientFrame, error)
+}
+
+var (
+	jsonCodec = &codec{
+		name:        "json",
+		subprotocol: "chat.v1.json",
+		messageType: websocket.TextMessage,
+		encode:      func(msg *models.WebSocketMessage) ([]byte, error) { return json.Marshal(msg) },
+		decodeFrame: func(message []byte) (*models.ClientFrame, error) { return decodeFrame(message), nil },
+	}
+	msgpackCodec = &codec{
+		name:        "msgpack",
+		subprotocol: "chat.v1.msgpack",
+		messageType: websocket.BinaryMessage,
+		encode:      encodeMsgpack,
+		decodeFrame: decodeMsgpackFrame,
+	}
+	protoCodec = &codec{
+		name:        "proto",
+		subprotocol: "chat.v1.proto",
+		messageType: websocket.BinaryMessage,
+		encode:      encodeProto,
+		decodeFrame: decodeProtoFrame,
+	}
+)
+
+// codecs are listed by preference: a client offering several subprotocols
+// gets the most compact one.
+var codecs = []*codec{protoCodec, msgpackCodec, jsonCodec}
+
+// Subprotocols returns the WebSocket subprotocols the server accepts, in order
+// of preference.
+func Subprotocols() []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.subprotocol
+	}
+	return names
+}
+
+// codecFor returns the codec of a negotiated subprotocol. Clients that didn't
+// ask for one speak JSON.
+func codecFor(subprotocol string) *codec {
+	for _, c := range codecs {
+		if c.subprotocol == subprotocol {
+			return c
+		}
+	}
+	return jsonCodec
+}
+
+// frameEncoder converts a hub's JSON frames to its clients' codecs, so a
+// broadcast is encoded once per codec rather than once per client. It is
+// owned by the hub goroutine and reset after every event it handles.
+type frameEncoder struct {
+	messages map[string]*models.WebSocketMessage
+	encoded  map[*codec]map[string][]byte
+}
+
+func newFrameEncoder() *frameEncoder {
+	return &frameEncoder{
+		messages: make(map[string]*models.WebSocketMessage),
+		encoded:  make(map[*codec]map[string][]byte),
+	}
+}
+
+// encode returns data in the given codec, or nil if it can't be converted.
+func (e *frameEncoder) encode(data []byte, c *codec) []byte {
+	if c == jsonCodec {
+		return data
+	}
+	if out, ok := e.encoded[c][string(data)]; ok {
+		return out
+	}
+
+	msg, ok := e.messages[string(data)]
+	if !ok {
+		msg = &models.WebSocketMessage{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			logger.Error("Error decoding frame for %s clients: %v", c.name, err)
+			msg = nil
+		}
+		e.messages[string(data)] = msg
+	}
+
+	var out []byte
+	if msg != nil {
+		var err error
+		if out, err = c.encode(msg); err != nil {
+			logger.Error("Error encoding %s frame as %s: %v", msg.Type, c.name, err)
+		}
+		metrics.WSFramesEncoded.WithLabelValues(c.name).Inc()
+	}
+	if e.encoded[c] == nil {
+		e.encoded[c] = make(map[string][]byte)
+	}
+	e.encoded[c][string(data)] = out
+	return out
+}
+
+func (e *frameEncoder) reset() {
+	clear(e.messages)
+	clear(e.encoded)
+}
+
+// encodeMsgpack encodes a message with the same keys as its JSON form.
+func encodeMsgpack(msg *models.WebSocketMessage) ([]byte, error) {
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeMsgpackFrame reads a client frame with the same keys as the JSON
+// envelope. The payload is re-encoded as JSON for the request handlers.
+func decodeMsgpackFrame(message []byte) (*models.ClientFrame, error) {
+	var raw struct {
+		Version int                `msgpack:"v"`
+		Type    string             `msgpack:"type"`
+		ID      string             `msgpack:"id"`
+		Room    int                `msgpack:"room_id"`
+		Data    msgpack.RawMessage `msgpack:"data"`
+	}
+	if err := msgpack.Unmarshal(message, &raw); err != nil {
+		return nil, err
+	}
+
+	frame := &models.ClientFrame{
+		Version: raw.Version,
+		Type:    models.ClientFrameType(raw.Type),
+		ID:      raw.ID,
+		Room:    raw.Room,
+	}
+	if len(raw.Data) > 0 {
+		var data any
+		if err := msgpack.Unmarshal(raw.Data, &data); err != nil {
+			return nil, err
+		}
+		var err error
+		if frame.Data, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+	}
+	return frame, nil
+}
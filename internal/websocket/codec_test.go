@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// TestMsgpackMatchesJSON checks that msgpack frames carry the same keys and
+// values as the JSON ones.
+func TestMsgpackMatchesJSON(t *testing.T) {
+	connectedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	messages := []*models.WebSocketMessage{
+		{Type: models.MessageTypeMessage, RoomID: 7, MessageID: 300, ClientID: "c1", Seq: 42, Text: "hi", Sender: "ann", UserID: 3, Timestamp: "2026-01-02T03:04:05Z"},
+		{Type: models.MessageTypeReaction, RoomID: 7, MessageID: 5, Emoji: "👍", Removed: true},
+		{Type: models.MessageTypeOnlineUsers, Users: []string{"ann", "bob"}, UserCount: 2},
+		{Type: models.MessageTypePresenceUpdate, ActiveUsers: []*models.ActiveUser{{
+			ID: 3, Username: "ann", Email: "ann@example.com", ConnectedAt: connectedAt, LastSeen: connectedAt, Connections: 2, Status: "online",
+		}}},
+		{Type: models.MessageTypeNack, RequestID: "r1", Code: "rate_limited", RetryAfter: 1500, Seq: -1},
+	}
+	for _, msg := range messages {
+		t.Run(string(msg.Type), func(t *testing.T) {
+			b, err := encodeMsgpack(msg)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			var got map[string]any
+			if err := msgpack.Unmarshal(b, &got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+
+			data, _ := json.Marshal(msg)
+			var want map[string]any
+			json.Unmarshal(data, &want)
+			if normalized := normalize(got); !reflect.DeepEqual(normalized, want) {
+				t.Errorf("msgpack frame %v, JSON frame %v", normalized, want)
+			}
+		})
+	}
+}
+
+// normalize converts decoded msgpack values to the types encoding/json
+// decodes to.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = normalize(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	default:
+		if n := reflect.ValueOf(v); n.CanInt() {
+			return float64(n.Int())
+		} else if n.CanUint() {
+			return float64(n.Uint())
+		}
+		return v
+	}
+}
+
+func TestMsgpackGolden(t *testing.T) {
+	msg := &models.WebSocketMessage{Type: models.MessageTypeAck, RequestID: "r1", Seq: 300}
+	const golden = "83a474797065a361636baa726571756573745f6964a27231a3736571cd012c"
+	got, err := encodeMsgpack(msg)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if hex.EncodeToString(got) != golden {
+		t.Errorf("encoded %x, want %s", got, golden)
+	}
+}
+
+func TestMsgpackFrame(t *testing.T) {
+	b, err := msgpack.Marshal(map[string]any{
+		"v": 1, "type": "edit", "id": "f2", "room_id": 7,
+		"data": map[string]any{"message_id": 300, "text": "fixed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeMsgpackFrame(b)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Version != 1 || got.Type != models.ClientFrameEdit || got.ID != "f2" || got.Room != 7 {
+		t.Errorf("decoded envelope %+v", got)
+	}
+	var req models.EditRequest
+	if err := json.Unmarshal(got.Data, &req); err != nil || req != (models.EditRequest{MessageID: 300, Text: "fixed"}) {
+		t.Errorf("decoded data %s: %v", got.Data, err)
+	}
+
+	if _, err := decodeMsgpackFrame([]byte{0x81, 0xa1}); err == nil {
+		t.Error("decoded a truncated frame")
+	}
+}
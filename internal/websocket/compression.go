@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// Upgrade upgrades the request to a WebSocket. When permessage-deflate is
+// negotiated the connection counts the bytes written to it, so clients can
+// report how much compression saves.
+func Upgrade(upgrader *websocket.Upgrader, w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	if upgrader.EnableCompression && offersDeflate(r.Header) {
+		w = &countingHijacker{ResponseWriter: w}
+	}
+	return upgrader.Upgrade(w, r, nil)
+}
+
+// offersDeflate reports whether the client offered permessage-deflate, which
+// is when gorilla negotiates it.
+func offersDeflate(header http.Header) bool {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for ext := range strings.SplitSeq(value, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type countingHijacker struct {
+	http.ResponseWriter
+}
+
+func (w *countingHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &deflateConn{Conn: conn}, brw, nil
+}
+
+// deflateConn is a connection that negotiated permessage-deflate. It counts
+// the bytes written, which WritePump compares with the frames it compressed.
+type deflateConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *deflateConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,195 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"chat-app/internal/metrics"
+	"chat-app/internal/models"
+	"chat-app/internal/tracing"
+	"chat-app/pkg/logger"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestHandler handles one type of client frame. A non-nil reply is sent
+// back to the client tagged with the frame's ID. sub is the room the frame
+// applies to, and nil for routes outside any room.
+type requestHandler func(c *Client, ctx context.Context, sub *subscription, frame *models.ClientFrame) (*models.WebSocketMessage, error)
+
+// route describes how a frame type is handled. Room routes need a
+// subscription to the frame's room; limited routes count against its flood
+// limits, or the connection's when outside a room.
+type route struct {
+	handle  requestHandler
+	room    bool
+	limited bool
+}
+
+var routes = map[models.ClientFrameType]route{
+	models.ClientFrameSendMessage: {handle: (*Client).handleSendMessage, room: true, limited: true},
+	models.ClientFrameEdit:        {handle: (*Client).handleEdit, room: true, limited: true},
+	models.ClientFrameReact:       {handle: (*Client).handleReact, room: true, limited: true},
+	models.ClientFrameTyping:      {handle: (*Client).handleTypingRequest, room: true},
+	models.ClientFrameAck:         {handle: (*Client).handleAck},
+	models.ClientFramePing:        {handle: (*Client).handlePing, limited: true},
+	models.ClientFrameSubscribe:   {handle: (*Client).handleSubscribe, limited: true},
+	models.ClientFrameUnsubscribe: {handle: (*Client).handleUnsubscribe, limited: true},
+}
+
+// requestError is a failed request reported to the client with its code.
+type requestError struct {
+	code string
+	text string
+}
+
+func (e *requestError) Error() string {
+	return e.text
+}
+
+// RequestErrorCode returns the protocol error code, such as bad_request, of a
+// request the server refused, or "" for any other error.
+func RequestErrorCode(err error) string {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return reqErr.code
+	}
+	return ""
+}
+
+func badRequest(format string, args ...any) error {
+	return &requestError{code: models.ErrorCodeBadRequest, text: fmt.Sprintf(format, args...)}
+}
+
+// decodeFrame parses an inbound frame. Anything that isn't a JSON object with
+// a type is chat text from a client predating the envelope, and the
+// typing_started/typing_stopped frames of those clients map to typing.
+func decodeFrame(message []byte) *models.ClientFrame {
+	if trimmed := bytes.TrimSpace(message); bytes.HasPrefix(trimmed, []byte("{")) {
+		var frame models.ClientFrame
+		if err := json.Unmarshal(trimmed, &frame); err == nil && frame.Type != "" {
+			switch models.MessageType(frame.Type) {
+			case models.MessageTypeTypingStarted, models.MessageTypeTypingStopped:
+				active := models.MessageType(frame.Type) == models.MessageTypeTypingStarted
+				frame.Type = models.ClientFrameTyping
+				frame.Data, _ = json.Marshal(models.TypingRequest{Active: active})
+			}
+			return &frame
+		}
+	}
+
+	data, _ := json.Marshal(models.SendMessageRequest{Text: string(message)})
+	return &models.ClientFrame{
+		Version: models.ProtocolVersion,
+		Type:    models.ClientFrameSendMessage,
+		Data:    data,
+	}
+}
+
+// decodeRequest unmarshals the frame's payload into v.
+func decodeRequest(frame *models.ClientFrame, v any) error {
+	if err := json.Unmarshal(frame.Data, v); err != nil {
+		return badRequest("invalid %s payload", frame.Type)
+	}
+	return nil
+}
+
+// dispatch routes a frame to its handler and replies with the outcome. It
+// returns false once the client has been disconnected for flooding.
+func (c *Client) dispatch(frame *models.ClientFrame) bool {
+	if frame.Version > models.ProtocolVersion {
+		c.sendError(frame, models.ErrorCodeUnsupportedVersion, fmt.Sprintf("protocol version %d is not supported", frame.Version), 0)
+		return true
+	}
+
+	route, ok := routes[frame.Type]
+	if !ok {
+		c.sendError(frame, models.ErrorCodeUnknownType, fmt.Sprintf("unknown frame type %q", frame.Type), 0)
+		return true
+	}
+
+	var sub *subscription
+	if route.room {
+		if sub = c.subscription(frame.Room); sub == nil {
+			c.sendError(frame, models.ErrorCodeNotSubscribed, "not subscribed to this room", 0)
+			return true
+		}
+	}
+
+	// Drop frames over the rate limit and disconnect repeat offenders
+	if route.limited {
+		if ok, retryAfter := c.allowMessage(sub); !ok {
+			metrics.WSMessagesRateLimited.Inc()
+			if c.recordViolation(frame, retryAfter) {
+				logger.InfoContext(c.ctx, "Disconnecting user %s for flooding", c.username)
+				c.closeWithPolicyViolation("message rate limit exceeded")
+				return false
+			}
+			return true
+		}
+	}
+
+	// Each request starts a new trace linked to the connection's session
+	ctx, span := tracing.Tracer().Start(c.ctx, "websocket.message",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(c.ctx)),
+		trace.WithAttributes(
+			attribute.Int("user.id", c.userID),
+			attribute.String("request.type", string(frame.Type)),
+		),
+	)
+	defer span.End()
+	if sub != nil {
+		span.SetAttributes(attribute.Int("room.id", sub.roomID))
+	}
+
+	reply, err := route.handle(c, ctx, sub, frame)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.replyError(ctx, frame, err)
+		return true
+	}
+	// Only requests with an ID or sends with a client ID can match a reply,
+	// but pings always get one
+	if reply != nil && (frame.ID != "" || reply.ClientID != "" || frame.Type == models.ClientFramePing) {
+		reply.RequestID = frame.ID
+		if sub != nil && reply.RoomID == 0 {
+			reply.RoomID = sub.roomID
+		}
+		if reply.Timestamp == "" {
+			reply.Timestamp = time.Now().Format(time.RFC3339)
+		}
+		c.sendDirect(ctx, reply)
+	}
+	return true
+}
+
+// replyError reports a failed request. Unexpected errors are logged and hidden
+// from the client.
+func (c *Client) replyError(ctx context.Context, frame *models.ClientFrame, err error) {
+	var reqErr *requestError
+	if !errors.As(err, &reqErr) {
+		logger.ErrorContext(ctx, "Error handling %s request: %v", frame.Type, err)
+		reqErr = &requestError{code: models.ErrorCodeInternal, text: "internal error"}
+	}
+	c.sendError(frame, reqErr.code, reqErr.text, 0)
+}
+
+// sendDirect queues a frame for this client only, dropping it if the client's
+// buffer is full.
+func (c *Client) sendDirect(ctx context.Context, msg *models.WebSocketMessage) {
+	data, err := c.codec.encode(msg)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error marshaling %s frame: %v", msg.Type, err)
+		return
+	}
+
+	c.queue.push(Frame{Ctx: ctx, Data: data})
+}
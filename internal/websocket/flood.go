@@ -0,0 +1,215 @@
+package websocket
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"chat-app/internal/config"
+	"chat-app/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// tokenBucket refills at rate tokens per second up to burst.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// take consumes one token if available, otherwise it reports how long until
+// the next token is available.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Minute
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// floodControl holds the limits for one room and the per-user buckets shared
+// by all of a user's connections to it.
+type floodControl struct {
+	cfg         config.FloodControlConfig
+	userBuckets map[int]*tokenBucket
+	typing      map[int]*typingState
+	mutex       sync.Mutex
+}
+
+// typingState throttles a user's typing frames across their connections.
+type typingState struct {
+	last   time.Time
+	active bool
+}
+
+func newFloodControl(cfg config.FloodControlConfig, room *models.Room) *floodControl {
+	// Room-level overrides apply to both client and user buckets
+	if room != nil {
+		if room.MessageRate != nil {
+			cfg.ClientRate = *room.MessageRate
+			cfg.UserRate = *room.MessageRate
+		}
+		if room.MessageBurst != nil {
+			cfg.ClientBurst = *room.MessageBurst
+			cfg.UserBurst = *room.MessageBurst
+		}
+	}
+
+	return &floodControl{
+		cfg:         cfg,
+		userBuckets: make(map[int]*tokenBucket),
+		typing:      make(map[int]*typingState),
+	}
+}
+
+func (f *floodControl) newClientBucket() *tokenBucket {
+	return newTokenBucket(f.cfg.ClientRate, f.cfg.ClientBurst)
+}
+
+func (f *floodControl) userBucket(userID int) *tokenBucket {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	bucket, exists := f.userBuckets[userID]
+	if !exists {
+		bucket = newTokenBucket(f.cfg.UserRate, f.cfg.UserBurst)
+		f.userBuckets[userID] = bucket
+	}
+	return bucket
+}
+
+// idle reports whether the bucket has refilled completely, so dropping it
+// loses nothing: a new one starts full.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// sweep drops user buckets that have refilled and typing state older than
+// typingTTL. Users are not released when they disconnect, so reconnecting
+// doesn't reset their limits.
+func (f *floodControl) sweep(now time.Time, typingTTL time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for userID, bucket := range f.userBuckets {
+		if bucket.idle(now) {
+			delete(f.userBuckets, userID)
+		}
+	}
+	for userID, state := range f.typing {
+		if now.Sub(state.last) > typingTTL {
+			delete(f.typing, userID)
+		}
+	}
+}
+
+// allowTyping accepts at most one typing_started per throttle interval from a
+// user, and a typing_stopped only after a typing_started was let through.
+func (f *floodControl) allowTyping(userID int, started bool, throttle time.Duration) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	state, exists := f.typing[userID]
+	if !exists {
+		state = &typingState{}
+		f.typing[userID] = state
+	}
+
+	if !started {
+		wasActive := state.active
+		state.active = false
+		return wasActive
+	}
+
+	now := time.Now()
+	if now.Sub(state.last) < throttle {
+		return false
+	}
+	state.last = now
+	state.active = true
+	return true
+}
+
+// allowMessage checks the client's bucket for the room and then the user's
+// bucket shared across their connections to it. Requests outside any room
+// only count against the connection's own bucket.
+func (c *Client) allowMessage(sub *subscription) (bool, time.Duration) {
+	now := time.Now()
+	if sub == nil {
+		return c.limiter.take(now)
+	}
+	if ok, wait := sub.limiter.take(now); !ok {
+		return false, wait
+	}
+	return sub.hub.flood.userBucket(c.userID).take(now)
+}
+
+// recordViolation notifies the sender that a frame was dropped and reports
+// whether the client has exceeded the allowed number of violations.
+func (c *Client) recordViolation(frame *models.ClientFrame, retryAfter time.Duration) bool {
+	now := time.Now()
+	if now.Sub(c.lastViolation) > c.flood.ViolationReset {
+		c.violations = 0
+	}
+	c.violations++
+	c.lastViolation = now
+
+	c.sendError(frame, models.ErrorCodeRateLimited, "message rate limit exceeded", retryAfter)
+	return c.violations > c.flood.MaxViolations
+}
+
+// sendError tells this client that the frame was not carried out. Sends that
+// carry a client ID get a nack instead of an error so the client knows to
+// retry or give up on that message.
+func (c *Client) sendError(frame *models.ClientFrame, code, text string, retryAfter time.Duration) {
+	msgType := models.MessageTypeError
+	var clientID string
+	if frame.Type == models.ClientFrameSendMessage {
+		var req models.SendMessageRequest
+		if json.Unmarshal(frame.Data, &req) == nil && req.ClientID != "" {
+			msgType = models.MessageTypeNack
+			clientID = req.ClientID
+		}
+	}
+
+	c.sendDirect(c.ctx, &models.WebSocketMessage{
+		Type:       msgType,
+		RoomID:     frame.Room,
+		RequestID:  frame.ID,
+		ClientID:   clientID,
+		Code:       code,
+		Text:       text,
+		RetryAfter: int(retryAfter.Milliseconds()),
+		Timestamp:  time.Now().Format(time.RFC3339),
+	})
+}
+
+// closeWithPolicyViolation tells the peer why it is being disconnected.
+func (c *Client) closeWithPolicyViolation(reason string) {
+	c.disconnect(websocket.ClosePolicyViolation, reason)
+}
@@ -3,173 +3,891 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"math/rand/v2"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"chat-app/internal/cluster"
+	"chat-app/internal/config"
 	"chat-app/internal/database"
+	"chat-app/internal/metrics"
 	"chat-app/internal/models"
+	"chat-app/internal/tracing"
 	"chat-app/pkg/logger"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Frame is an encoded message queued for delivery. Ctx carries the trace of
+// the operation that produced it so each hop can be attributed to it.
+// Seq is set for chat messages so clients can skip ones they were already
+// replayed, and Room for frames a hub sends so clients can hold them back
+// while the room is replayed. Kind marks the frames a slow client can do
+// without.
+type Frame struct {
+	Ctx  context.Context
+	Data []byte
+	Seq  int64
+	Room int
+	Kind frameKind
+
+	queued time.Time
+}
+
+// ErrShuttingDown is returned for connections arriving after shutdown began.
+var ErrShuttingDown = errors.New("server shutting down")
+
+const shutdownReason = "server shutting down"
+
+// subscribeTimeout bounds the wait for the cluster broker to confirm a new
+// hub's subscription. A hub the broker doesn't confirm in time serves its
+// local clients and keeps retrying, up to maxSubscribeRetry apart.
+const (
+	subscribeTimeout  = 5 * time.Second
+	maxSubscribeRetry = time.Minute
 )
 
 type Hub struct {
 	clients       map[*Client]bool
-	Broadcast     chan []byte
+	Broadcast     chan Frame
+	remote        chan Frame
 	Register      chan *Client
 	Unregister    chan *Client
 	roomID        int
-	onlineUsers   map[string]bool
+	presence      *presenceTracker
+	presenceSync  chan []*models.ActiveUser
+	heartbeats    *heartbeats
+	typing        *typingTracker
+	typingUpdates chan typingUpdate
 	shutdown      chan bool
+	stopOnce      sync.Once
+	drain         chan time.Duration
+	draining      bool
+	disconnect    chan disconnectRequest
+	done          chan struct{}
 	lastActivity  time.Time
+	connected     atomic.Int32
+	encoder       *frameEncoder
 	db            database.Database
+	flood         *floodControl
+	settings      *atomic.Pointer[config.WebSocketConfig]
+	bus           cluster.Broker
+	// slowConsumer overrides websocket.slow_consumer_policy for the room.
+	slowConsumer string
 }
 
-func NewHub(roomID int, db database.Database) *Hub {
+func NewHub(roomID int, db database.Database, flood *floodControl, settings *atomic.Pointer[config.WebSocketConfig], bus cluster.Broker, heartbeats *heartbeats, slowConsumer string) *Hub {
 	return &Hub{
 		clients:       make(map[*Client]bool),
-		Broadcast:     make(chan []byte),
+		Broadcast:     make(chan Frame),
+		remote:        make(chan Frame),
 		Register:      make(chan *Client),
 		Unregister:    make(chan *Client),
 		roomID:        roomID,
-		onlineUsers:   make(map[string]bool),
+		presence:      newPresenceTracker(),
+		presenceSync:  make(chan []*models.ActiveUser),
+		heartbeats:    heartbeats,
+		typing:        newTypingTracker(),
+		typingUpdates: make(chan typingUpdate),
 		shutdown:      make(chan bool),
+		drain:         make(chan time.Duration),
+		disconnect:    make(chan disconnectRequest),
+		done:          make(chan struct{}),
 		lastActivity:  time.Now(),
+		encoder:       newFrameEncoder(),
 		db:            db,
+		flood:         flood,
+		settings:      settings,
+		bus:           bus,
+		slowConsumer:  slowConsumer,
 	}
 }
 
 func (h *Hub) Run() {
+	defer close(h.done)
+
+	var typingExpiry <-chan time.Time
 	for {
 		select {
 		case <-h.shutdown:
 			for client := range h.clients {
-				close(client.send)
+				client.evict(h.roomID, websocket.CloseTryAgainLater, "room closed")
 			}
 			return
 
+		case reconnectDelay := <-h.drain:
+			h.draining = true
+			for client := range h.clients {
+				h.sendGoodbye(client, reconnectDelay)
+			}
+			if len(h.clients) == 0 {
+				return
+			}
+
 		case client := <-h.Register:
 			h.clients[client] = true
+			if h.draining {
+				h.sendGoodbye(client, 0)
+				break
+			}
 			h.lastActivity = time.Now()
-			h.onlineUsers[client.username] = true
-			h.broadcastPresenceUpdate()
-			logger.Info("User %s joined room %d", client.username, h.roomID)
+			h.join(client)
+			logger.InfoContext(client.ctx, "User %s joined room %d", client.username, h.roomID)
 
 		case client := <-h.Unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.send)
-				delete(h.onlineUsers, client.username)
-				h.broadcastPresenceUpdate()
-				logger.Info("User %s left room %d", client.username, h.roomID)
+				h.leave(client)
+				logger.InfoContext(client.ctx, "User %s left room %d", client.username, h.roomID)
+			}
+
+			// A draining hub stops once its last client is gone
+			if h.draining && len(h.clients) == 0 {
+				return
 			}
 
-		case message := <-h.Broadcast:
+		case frame := <-h.Broadcast:
+			if h.draining {
+				break
+			}
+			h.lastActivity = time.Now()
+			metrics.WSBroadcasts.Inc()
+
+			ctx, span := tracing.Tracer().Start(frame.Ctx, "hub.broadcast")
+			span.SetAttributes(
+				attribute.Int("room.id", h.roomID),
+				attribute.Int("hub.recipients", len(h.clients)),
+			)
+			h.broadcastToAll(Frame{Ctx: ctx, Data: frame.Data, Seq: frame.Seq})
+			h.publish(ctx, frame.Data)
+			span.End()
+
+		case frame := <-h.remote:
+			if h.draining {
+				break
+			}
 			h.lastActivity = time.Now()
-			h.broadcastToAll(message)
+			h.handleRemote(frame)
+
+		case update := <-h.typingUpdates:
+			if h.draining {
+				break
+			}
+			h.applyTyping(update, true)
+
+		case <-typingExpiry:
+			if h.typing.expire(time.Now()) && !h.draining {
+				h.broadcastTyping(context.Background(), 0)
+			}
+
+		case users := <-h.presenceSync:
+			joined, left := h.presence.syncRemote(users)
+			for _, entry := range joined {
+				h.broadcastToAll(Frame{Ctx: context.Background(), Data: userEvent(models.MessageTypeUserJoined, h.roomID, entry.userID, entry.username), Kind: framePresence})
+			}
+			for _, entry := range left {
+				h.broadcastToAll(Frame{Ctx: context.Background(), Data: userEvent(models.MessageTypeUserLeft, h.roomID, entry.userID, entry.username), Kind: framePresence})
+			}
+
+		case req := <-h.disconnect:
+			for client := range h.clients {
+				if !req.match(client) {
+					continue
+				}
+				logger.InfoContext(client.ctx, "Removing user %s from room %d: %s", client.username, h.roomID, req.reason)
+				if req.evict {
+					client.evict(h.roomID, websocket.ClosePolicyViolation, req.reason)
+				} else {
+					go client.disconnect(websocket.ClosePolicyViolation, req.reason)
+				}
+			}
 		}
+
+		// Published for readers outside the hub goroutine
+		h.connected.Store(int32(len(h.clients)))
+		typingExpiry = h.typing.schedule()
+		h.encoder.reset()
 	}
 }
 
-func (h *Hub) broadcastToAll(message []byte) {
+func (h *Hub) broadcastToAll(frame Frame) {
+	h.broadcastEach(func(*Client) Frame { return frame })
+}
+
+// broadcastEach sends every client the frame built for it, dropping clients
+// that fall too far behind for the room's slow-consumer policy. Clients given
+// a frame without data are skipped.
+func (h *Hub) broadcastEach(frameFor func(*Client) Frame) {
+	var dropped []*Client
 	for client := range h.clients {
-		select {
-		case client.send <- message:
-		default:
-			close(client.send)
+		frame := frameFor(client)
+		if frame.Data == nil {
+			continue
+		}
+		if !h.sendTo(client, frame) {
+			metrics.WSMessagesDropped.Inc()
+			metrics.WSSlowClientsDropped.Inc()
+			client.close(websocket.CloseTryAgainLater, "client too slow")
 			delete(h.clients, client)
-			delete(h.onlineUsers, client.username)
+			dropped = append(dropped, client)
 		}
 	}
+
+	for _, client := range dropped {
+		h.leave(client)
+	}
 }
 
-func (h *Hub) broadcastPresenceUpdate() {
-	ctx := context.Background()
-	activeUsers, err := h.db.GetActiveUsersInRoom(ctx, h.roomID)
-	if err != nil {
-		logger.Error("Error getting active users for presence update: %v", err)
+// sendTo queues a frame for one client. When the client's queue is full the
+// room's slow-consumer policy decides what gives way, and false is returned
+// if the client must be dropped. Clients already closing are skipped.
+func (h *Hub) sendTo(client *Client, frame Frame) bool {
+	if client.closed() {
+		return true
+	}
+
+	frame = h.encodeFor(client, frame)
+	if frame.Data == nil {
+		return true
+	}
+	if client.queue.push(frame) {
+		return true
+	}
+
+	policy := h.slowConsumerPolicy()
+	metrics.WSSendQueueFull.WithLabelValues(policy).Inc()
+	switch policy {
+	case models.SlowConsumerDropOldest:
+		return client.queue.dropOldest(frame)
+	case models.SlowConsumerCoalesce:
+		return client.queue.coalesce(frame, func() Frame {
+			return h.encodeFor(client, h.rosterFrame(client.ctx))
+		})
+	}
+	return false
+}
+
+// encodeFor tags a frame with the room and encodes it for the client's codec.
+func (h *Hub) encodeFor(client *Client, frame Frame) Frame {
+	frame.Data = h.encoder.encode(frame.Data, client.codec)
+	frame.Room = h.roomID
+	return frame
+}
+
+// slowConsumerPolicy returns what happens to the room's clients that fall
+// behind: they are disconnected, lose their oldest presence and typing
+// frames, or have those merged.
+func (h *Hub) slowConsumerPolicy() string {
+	if h.slowConsumer != "" {
+		return h.slowConsumer
+	}
+	return h.config().SlowConsumerPolicy
+}
+
+// sendGoodbye queues a reconnect hint as the client's last frame and closes
+// the client, so WritePump flushes what is pending and then sends a
+// going-away close frame.
+func (h *Hub) sendGoodbye(client *Client, reconnectDelay time.Duration) {
+	if client.closed() {
 		return
 	}
 
-	presenceMsg := models.WebSocketMessage{
+	// Spread reconnects so clients don't all return at once
+	retryAfter := reconnectDelay
+	if reconnectDelay > 0 {
+		retryAfter += rand.N(reconnectDelay)
+	}
+
+	goodbye := models.WebSocketMessage{
+		Type:       models.MessageTypeReconnect,
+		RoomID:     h.roomID,
+		Text:       shutdownReason,
+		RetryAfter: int(retryAfter.Milliseconds()),
+		Timestamp:  time.Now().Format(time.RFC3339),
+	}
+	// Sent untagged so it isn't held back behind a pending replay
+	if data, err := client.codec.encode(&goodbye); err == nil {
+		client.queue.push(Frame{Ctx: client.ctx, Data: data})
+	}
+
+	client.close(websocket.CloseGoingAway, shutdownReason)
+}
+
+// join counts the client's connection, sends it the room's roster and
+// announces the user if they were not online anywhere yet. Other instances
+// only hear about a user's first connection here.
+func (h *Hub) join(client *Client) {
+	h.heartbeats.add(client.sessionID)
+	localJoined, joined := h.presence.addLocal(client.userID, client.username)
+
+	h.sendTo(client, h.rosterFrame(client.ctx))
+
+	event := userEvent(models.MessageTypeUserJoined, h.roomID, client.userID, client.username)
+	if joined {
+		h.broadcastToAll(Frame{Ctx: client.ctx, Data: event, Kind: framePresence})
+	}
+	if localJoined {
+		h.publish(client.ctx, event)
+	}
+}
+
+// rosterFrame lists who is online in the room.
+func (h *Hub) rosterFrame(ctx context.Context) Frame {
+	roster := h.presence.snapshot()
+	data, err := json.Marshal(models.WebSocketMessage{
 		Type:        models.MessageTypePresenceUpdate,
-		ActiveUsers: activeUsers,
-		UserCount:   len(activeUsers),
+		RoomID:      h.roomID,
+		ActiveUsers: roster,
+		UserCount:   len(roster),
 		Timestamp:   time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "Error marshaling presence update: %v", err)
 	}
+	return Frame{Ctx: ctx, Data: data, Kind: framePresence}
+}
+
+// leave drops the client's connection and announces the user once they are
+// offline everywhere. Other instances are told even while draining so they
+// don't keep showing users whose connections moved elsewhere.
+func (h *Hub) leave(client *Client) {
+	h.heartbeats.remove(client.sessionID)
+	localLeft, left := h.presence.removeLocal(client.userID)
 
-	if data, err := json.Marshal(presenceMsg); err == nil {
-		h.broadcastToAll(data)
-	} else {
-		logger.Error("Error marshaling presence update: %v", err)
+	event := userEvent(models.MessageTypeUserLeft, h.roomID, client.userID, client.username)
+	if left && !h.draining {
+		h.broadcastToAll(Frame{Ctx: client.ctx, Data: event, Kind: framePresence})
+	}
+	if localLeft {
+		h.publish(client.ctx, event)
+		if _, typing := h.typing.users[client.userID]; typing {
+			h.applyTyping(typingUpdate{ctx: client.ctx, userID: client.userID, username: client.username}, true)
+		}
 	}
 }
 
-func (h *Hub) GetOnlineUserCount() int {
-	return len(h.onlineUsers)
+// handleRemote applies presence events from other instances before passing
+// them on, so clients only see a user join or leave when that changes who is
+// online in the room. Anything else is delivered as is.
+func (h *Hub) handleRemote(frame Frame) {
+	var msg models.WebSocketMessage
+	if err := json.Unmarshal(frame.Data, &msg); err != nil {
+		h.broadcastToAll(frame)
+		return
+	}
+
+	switch msg.Type {
+	case models.MessageTypeUserJoined:
+		if !h.presence.addRemote(msg.UserID, msg.Username) {
+			return
+		}
+		frame.Kind = framePresence
+	case models.MessageTypeUserLeft:
+		// The instance removed its sessions before reporting the leave;
+		// the resync announces the user if no other session remains.
+		go h.syncPresence(context.WithoutCancel(frame.Ctx))
+		return
+	case models.MessageTypeMessage:
+		frame.Seq = msg.Seq
+	case models.MessageTypeTypingStarted, models.MessageTypeTypingStopped:
+		started := msg.Type == models.MessageTypeTypingStarted
+		h.applyTyping(typingUpdate{ctx: frame.Ctx, userID: msg.UserID, username: msg.Username, started: started}, false)
+		return
+	}
+	h.broadcastToAll(frame)
 }
 
-func (h *Hub) ShutdownHub() {
+// publish relays a local broadcast to the room's clients on other instances.
+func (h *Hub) publish(ctx context.Context, data []byte) {
+	h.bus.Publish(ctx, h.roomID, data)
+}
+
+// deliverRemote hands the hub a broadcast published by another instance.
+func (h *Hub) deliverRemote(ctx context.Context, data []byte) {
+	select {
+	case h.remote <- Frame{Ctx: ctx, Data: data}:
+	case <-h.done:
+	}
+}
+
+// disconnectRequest asks the hub to close every client matching the filter,
+// or with evict only to remove them from the room.
+type disconnectRequest struct {
+	match  func(*Client) bool
+	reason string
+	evict  bool
+}
+
+func (h *Hub) disconnectWhere(match func(*Client) bool, reason string) {
+	h.request(disconnectRequest{match: match, reason: reason})
+}
+
+func (h *Hub) request(req disconnectRequest) {
+	select {
+	case h.disconnect <- req:
+	case <-h.done:
+	}
+}
+
+// DisconnectSession closes every client in the hub that was authenticated with
+// the given login session.
+func (h *Hub) DisconnectSession(sessionID string) {
+	h.disconnectWhere(func(c *Client) bool { return c.authSessionID == sessionID }, "session revoked")
+}
+
+// DisconnectUser closes every client in the hub belonging to the user.
+func (h *Hub) DisconnectUser(userID int, reason string) {
+	h.disconnectWhere(func(c *Client) bool { return c.userID == userID }, reason)
+}
+
+// DisconnectAll closes every client in the hub.
+func (h *Hub) DisconnectAll(reason string) {
+	h.disconnectWhere(func(c *Client) bool { return true }, reason)
+}
+
+// EvictAll removes every client from the room. Multiplexed connections keep
+// their other rooms.
+func (h *Hub) EvictAll(reason string) {
+	h.request(disconnectRequest{match: func(*Client) bool { return true }, reason: reason, evict: true})
+}
+
+// ClientCount returns the number of connections currently in the hub.
+func (h *Hub) ClientCount() int {
+	return int(h.connected.Load())
+}
+
+// Drain asks every client to reconnect after roughly reconnectDelay and stops
+// the hub once they have all disconnected.
+func (h *Hub) Drain(reconnectDelay time.Duration) {
+	select {
+	case h.drain <- reconnectDelay:
+	case <-h.done:
+	}
+}
+
+// register adds the client and reports false if the hub has already stopped.
+func (h *Hub) register(client *Client) bool {
 	select {
-	case h.shutdown <- true:
+	case h.Register <- client:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// unregister removes the client, unless the hub has already stopped.
+func (h *Hub) unregister(client *Client) {
+	select {
+	case h.Unregister <- client:
+	case <-h.done:
+	}
+}
+
+// stopped reports whether the hub has stopped running.
+func (h *Hub) stopped() bool {
+	select {
+	case <-h.done:
+		return true
 	default:
+		return false
 	}
 }
 
+// ShutdownHub stops the hub, evicting any clients left. It is safe to call
+// more than once.
+func (h *Hub) ShutdownHub() {
+	h.stopOnce.Do(func() { close(h.shutdown) })
+}
+
+// config returns the current WebSocket settings, which may change on reload.
+func (h *Hub) config() config.WebSocketConfig {
+	return *h.settings.Load()
+}
+
 func (h *Hub) StartCleanupRoutine() {
-	ticker := time.NewTicker(30 * time.Minute)
-	defer ticker.Stop()
+	for {
+		idleTimeout := h.config().HubIdleTimeout
+		select {
+		case <-time.After(idleTimeout):
+		case <-h.done:
+			return
+		}
 
-	for range ticker.C {
-		if time.Since(h.lastActivity) > 30*time.Minute && len(h.clients) == 0 {
+		if time.Since(h.lastActivity) > idleTimeout && h.ClientCount() == 0 {
 			h.ShutdownHub()
 			return
 		}
+		settings := h.config()
+		h.flood.sweep(time.Now(), max(settings.TypingThrottle, settings.TypingTimeout))
 	}
 }
 
 // Hub Manager
 type Manager struct {
-	hubs   map[int]*Hub
-	mutex  sync.Mutex
-	db     database.Database
+	hubs map[int]*Hub
+	// busy holds the rooms whose hub or broker subscription is being set up,
+	// closed once that is done
+	busy       map[int]chan struct{}
+	mutex      sync.Mutex
+	db         database.Database
+	flood      config.FloodControlConfig
+	settings   atomic.Pointer[config.WebSocketConfig]
+	bus        cluster.Broker
+	heartbeats *heartbeats
+	polls      map[string]*pollSession
+	closed     bool
 }
 
-func NewManager(db database.Database) *Manager {
+// NewManager creates the hub manager. Every hub publishes its broadcasts to
+// bus and delivers what other instances publish for its room.
+func NewManager(db database.Database, settings config.WebSocketConfig, flood config.FloodControlConfig, bus cluster.Broker) *Manager {
 	manager := &Manager{
-		hubs: make(map[int]*Hub),
-		db:   db,
+		hubs:       make(map[int]*Hub),
+		busy:       make(map[int]chan struct{}),
+		db:         db,
+		flood:      flood,
+		bus:        bus,
+		heartbeats: newHeartbeats(),
+		polls:      make(map[string]*pollSession),
 	}
-	
+	manager.settings.Store(&settings)
+
 	go manager.cleanupUnusedHubs()
+	go manager.heartbeatLoop()
+	go manager.reaperLoop()
 	return manager
 }
 
-func (m *Manager) GetHubForRoom(roomID int) *Hub {
+func (m *Manager) GetHubForRoom(ctx context.Context, roomID int) (*Hub, error) {
+	for {
+		m.mutex.Lock()
+		if m.closed {
+			m.mutex.Unlock()
+			return nil, ErrShuttingDown
+		}
+		if hub, exists := m.hubs[roomID]; exists {
+			if !hub.stopped() {
+				m.mutex.Unlock()
+				return hub, nil
+			}
+			// The hub stopped for being idle; the new hub takes over its
+			// subscription
+			m.removeHub(roomID, "idle")
+		}
+		busy, taken := m.busy[roomID]
+		if !taken {
+			m.busy[roomID] = make(chan struct{})
+		}
+		m.mutex.Unlock()
+
+		if !taken {
+			defer m.release(roomID)
+			return m.createHub(ctx, roomID)
+		}
+
+		// Someone else is setting the hub up; use it once ready, or try
+		// again if they failed
+		select {
+		case <-busy:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// acquire waits until nobody else is setting up the room, then marks it busy
+// until release is called.
+func (m *Manager) acquire(roomID int) {
+	for {
+		m.mutex.Lock()
+		busy, taken := m.busy[roomID]
+		if !taken {
+			m.busy[roomID] = make(chan struct{})
+			m.mutex.Unlock()
+			return
+		}
+		m.mutex.Unlock()
+		<-busy
+	}
+}
+
+func (m *Manager) release(roomID int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	close(m.busy[roomID])
+	delete(m.busy, roomID)
+}
+
+// createHub sets up the room's hub without holding the mutex, which only
+// guards adding it.
+func (m *Manager) createHub(ctx context.Context, roomID int) (*Hub, error) {
+	// Rooms may override the default message rate and slow-consumer policy
+	room, err := m.db.GetRoomByID(ctx, roomID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error loading room %d limits: %v", roomID, err)
+	}
+	var slowConsumer string
+	if room != nil && room.SlowConsumerPolicy != nil {
+		slowConsumer = *room.SlowConsumerPolicy
+	}
+	hub := NewHub(roomID, m.db, newFloodControl(m.floodConfig(), room), &m.settings, m.bus, m.heartbeats, slowConsumer)
+
+	// Clients replay what they missed once they have joined, so remote
+	// messages should arrive from before then. While the broker is down the
+	// hub only serves this instance.
+	subscribed := m.subscribe(ctx, hub) == nil
+
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		if subscribed {
+			m.bus.Unsubscribe(roomID)
+		}
+		return nil, ErrShuttingDown
+	}
+	defer m.mutex.Unlock()
+
+	if !subscribed {
+		go m.resubscribe(hub)
+	}
+
+	m.hubs[roomID] = hub
+	metrics.HubsCreated.Inc()
+	metrics.HubsActive.Inc()
+	go hub.Run()
+	go hub.StartCleanupRoutine()
+	// Seed the view of users connected to other instances
+	go hub.syncPresence(context.WithoutCancel(ctx))
+	return hub, nil
+}
+
+// liveHubs returns the current hubs, so they can be called without holding
+// the mutex.
+func (m *Manager) liveHubs() []*Hub {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub)
+	}
+	return hubs
+}
+
+// Shutdown drains every hub: clients receive a reconnect hint and a
+// going-away close frame after their pending messages. It waits for them to
+// disconnect until ctx expires, then closes the remaining connections.
+func (m *Manager) Shutdown(ctx context.Context, reconnectDelay time.Duration) error {
+	m.mutex.Lock()
+	m.closed = true
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for roomID, hub := range m.hubs {
+		hubs = append(hubs, hub)
+		m.removeHub(roomID, "shutdown")
+	}
+	m.mutex.Unlock()
+
+	for _, hub := range hubs {
+		m.unsubscribe(hub.roomID)
+	}
+
+	// Leaving clients remove their own sessions, but those deletes may still
+	// be running when the hubs finish, so they are removed here in bulk too.
+	sessionIDs := m.heartbeats.list()
+	defer func() {
+		m.removeSessions(ctx, append(sessionIDs, m.heartbeats.list()...))
+	}()
+
+	for _, hub := range hubs {
+		hub.Drain(reconnectDelay)
+	}
+
+	for _, hub := range hubs {
+		select {
+		case <-hub.done:
+		case <-ctx.Done():
+			for _, hub := range hubs {
+				hub.DisconnectAll(shutdownReason)
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// removeSessions deletes this instance's active sessions, allowing a write
+// timeout past ctx so they are removed even when the drain ran out of time.
+func (m *Manager) removeSessions(ctx context.Context, sessionIDs []string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.config().WriteTimeout)
+	defer cancel()
+	if err := m.db.RemoveActiveSessions(ctx, sessionIDs); err != nil {
+		logger.Error("Error removing %d active sessions: %v", len(sessionIDs), err)
+	}
+}
+
+// SetConfig applies reloaded settings. Connection settings take effect for
+// new connections and flood limits for hubs created from now on.
+func (m *Manager) SetConfig(settings config.WebSocketConfig, flood config.FloodControlConfig) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.settings.Store(&settings)
+	m.flood = flood
+}
+
+func (m *Manager) config() config.WebSocketConfig {
+	return *m.settings.Load()
+}
+
+func (m *Manager) floodConfig() config.FloodControlConfig {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	return m.flood
+}
 
+// Stats returns the number of live hubs and the room subscriptions across
+// them.
+func (m *Manager) Stats() (hubs, clients int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, hub := range m.hubs {
+		clients += hub.ClientCount()
+	}
+	return len(m.hubs), clients
+}
+
+// DisconnectSession closes the session's connections across all hubs.
+func (m *Manager) DisconnectSession(sessionID string) {
+	for _, hub := range m.liveHubs() {
+		hub.DisconnectSession(sessionID)
+	}
+}
+
+// DisconnectUser closes the user's connections across all hubs.
+func (m *Manager) DisconnectUser(userID int, reason string) {
+	for _, hub := range m.liveHubs() {
+		hub.DisconnectUser(userID, reason)
+	}
+}
+
+// CloseRoom removes the room's hub, evicts everyone from it and waits for it
+// to stop.
+func (m *Manager) CloseRoom(roomID int, reason string) {
+	m.mutex.Lock()
 	hub, exists := m.hubs[roomID]
+	if exists {
+		m.removeHub(roomID, "room_closed")
+	}
+	m.mutex.Unlock()
 	if !exists {
-		hub = NewHub(roomID, m.db)
-		m.hubs[roomID] = hub
-		go hub.Run()
-		go hub.StartCleanupRoutine()
+		return
+	}
+	m.unsubscribe(roomID)
+
+	hub.EvictAll(reason)
+	hub.ShutdownHub()
+	<-hub.done
+}
+
+// subscribe routes the room's broadcasts from other instances to the hub,
+// returning once the broker delivers them.
+func (m *Manager) subscribe(ctx context.Context, hub *Hub) error {
+	ctx, cancel := context.WithTimeout(ctx, subscribeTimeout)
+	defer cancel()
+
+	if err := m.bus.Subscribe(ctx, hub.roomID, hub.deliverRemote); err != nil {
+		m.bus.Unsubscribe(hub.roomID)
+		logger.ErrorContext(ctx, "Error subscribing to room %d across instances: %v", hub.roomID, err)
+		return err
+	}
+	return nil
+}
+
+// resubscribe retries a hub's failed subscription until it succeeds or the
+// hub stops.
+func (m *Manager) resubscribe(hub *Hub) {
+	delay := time.Second
+	for {
+		select {
+		case <-time.After(delay):
+		case <-hub.done:
+			return
+		}
+		if m.retrySubscribe(hub) {
+			return
+		}
+		delay = min(2*delay, maxSubscribeRetry)
+	}
+}
+
+// retrySubscribe subscribes the hub again if it is still the room's hub. It
+// reports false if the attempt failed and should be retried.
+func (m *Manager) retrySubscribe(hub *Hub) bool {
+	m.acquire(hub.roomID)
+	defer m.release(hub.roomID)
+
+	m.mutex.Lock()
+	current := m.hubs[hub.roomID] == hub
+	m.mutex.Unlock()
+	if !current {
+		return true
+	}
+
+	if err := m.subscribe(context.Background(), hub); err != nil {
+		return false
+	}
+	logger.Info("Subscribed to room %d across instances", hub.roomID)
+	return true
+}
+
+// removeHub forgets the room's hub; callers hold the mutex and call
+// unsubscribe for the room once they released it.
+func (m *Manager) removeHub(roomID int, reason string) {
+	delete(m.hubs, roomID)
+	metrics.HubsRemoved.WithLabelValues(reason).Inc()
+	metrics.HubsActive.Dec()
+}
+
+// unsubscribe drops the broker subscription of a room whose hub was removed,
+// unless a new hub has taken the room over since.
+func (m *Manager) unsubscribe(roomID int) {
+	m.acquire(roomID)
+	defer m.release(roomID)
+
+	m.mutex.Lock()
+	_, reopened := m.hubs[roomID]
+	m.mutex.Unlock()
+	if !reopened {
+		m.bus.Unsubscribe(roomID)
 	}
-	return hub
 }
 
 func (m *Manager) cleanupUnusedHubs() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+	for {
+		time.Sleep(m.settings.Load().HubCleanupInterval)
 
-	for range ticker.C {
+		var removed []int
 		m.mutex.Lock()
 		for roomID, hub := range m.hubs {
-			if hub.GetOnlineUserCount() == 0 {
+			if hub.ClientCount() == 0 {
 				hub.ShutdownHub()
-				delete(m.hubs, roomID)
+				m.removeHub(roomID, "idle")
+				removed = append(removed, roomID)
 				logger.Debug("Cleaned up unused hub for room %d", roomID)
 			}
 		}
 		m.mutex.Unlock()
+
+		for _, roomID := range removed {
+			m.unsubscribe(roomID)
+		}
 	}
-}
\ No newline at end of file
+}
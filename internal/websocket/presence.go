@@ -0,0 +1,251 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/pkg/logger"
+)
+
+// presenceEntry is one user's presence in a room.
+type presenceEntry struct {
+	userID      int
+	username    string
+	connections int
+	since       time.Time
+}
+
+// presenceTracker keeps a room's presence in memory. Local users are counted
+// per connection so a user with several tabs stays online until the last one
+// closes. Remote users are added as other instances report them joining and
+// reloaded from the database when one reports a user leaving, since the user
+// may still be connected to a third. It is owned by the hub goroutine.
+type presenceTracker struct {
+	local  map[int]*presenceEntry
+	remote map[int]*presenceEntry
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		local:  make(map[int]*presenceEntry),
+		remote: make(map[int]*presenceEntry),
+	}
+}
+
+func (p *presenceTracker) online(userID int) bool {
+	return p.local[userID] != nil || p.remote[userID] != nil
+}
+
+// addLocal counts a new connection. localJoined reports the user's first
+// connection on this instance; joined that they were not online anywhere.
+func (p *presenceTracker) addLocal(userID int, username string) (localJoined, joined bool) {
+	joined = !p.online(userID)
+
+	entry, ok := p.local[userID]
+	if !ok {
+		entry = &presenceEntry{userID: userID, username: username, since: time.Now()}
+		p.local[userID] = entry
+	}
+	entry.connections++
+	return !ok, joined
+}
+
+// removeLocal drops a connection. localLeft reports that the user's last
+// connection on this instance closed; left that they are now offline
+// everywhere.
+func (p *presenceTracker) removeLocal(userID int) (localLeft, left bool) {
+	entry, ok := p.local[userID]
+	if !ok {
+		return false, false
+	}
+
+	entry.connections--
+	if entry.connections > 0 {
+		return false, false
+	}
+	delete(p.local, userID)
+	return true, !p.online(userID)
+}
+
+// addRemote records that another instance reported the user joining and
+// reports whether they were offline until now.
+func (p *presenceTracker) addRemote(userID int, username string) bool {
+	joined := !p.online(userID)
+	if p.remote[userID] == nil {
+		p.remote[userID] = &presenceEntry{userID: userID, username: username, since: time.Now()}
+	}
+	return joined
+}
+
+// syncRemote reconciles the remote view with the sessions recorded in the
+// database, correcting drift from missed events or crashed instances.
+// Sessions beyond a user's local connections must belong to other instances.
+// It returns the users who appeared and disappeared as a result.
+func (p *presenceTracker) syncRemote(users []*models.ActiveUser) (joined, left []*presenceEntry) {
+	seen := make(map[int]bool, len(users))
+	for _, user := range users {
+		localConnections := 0
+		if entry := p.local[user.ID]; entry != nil {
+			localConnections = entry.connections
+		}
+		if user.Connections <= localConnections {
+			continue
+		}
+
+		seen[user.ID] = true
+		if p.remote[user.ID] != nil {
+			continue
+		}
+		entry := &presenceEntry{userID: user.ID, username: user.Username, since: user.ConnectedAt}
+		p.remote[user.ID] = entry
+		if p.local[user.ID] == nil {
+			joined = append(joined, entry)
+		}
+	}
+
+	for userID, entry := range p.remote {
+		if seen[userID] {
+			continue
+		}
+		delete(p.remote, userID)
+		if p.local[userID] == nil {
+			left = append(left, entry)
+		}
+	}
+	return joined, left
+}
+
+// snapshot lists everyone online in the room, ordered by username.
+func (p *presenceTracker) snapshot() []*models.ActiveUser {
+	users := make([]*models.ActiveUser, 0, len(p.local)+len(p.remote))
+	add := func(entry *presenceEntry) {
+		users = append(users, &models.ActiveUser{
+			ID:          entry.userID,
+			Username:    entry.username,
+			ConnectedAt: entry.since,
+			Status:      "online",
+		})
+	}
+
+	for _, entry := range p.local {
+		add(entry)
+	}
+	for userID, entry := range p.remote {
+		if p.local[userID] == nil {
+			add(entry)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// userEvent builds the frame announcing a change to one user, such as joining
+// the room or starting to type.
+func userEvent(msgType models.MessageType, roomID, userID int, username string) []byte {
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:      msgType,
+		RoomID:    roomID,
+		UserID:    userID,
+		Username:  username,
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		logger.Error("Error marshaling user event: %v", err)
+	}
+	return data
+}
+
+// heartbeats tracks the connections on this instance so their active_sessions
+// rows can be refreshed in one batched update per interval. A multiplexed
+// connection is counted once per room it is in.
+type heartbeats struct {
+	mutex    sync.Mutex
+	sessions map[string]int
+}
+
+func newHeartbeats() *heartbeats {
+	return &heartbeats{sessions: make(map[string]int)}
+}
+
+func (h *heartbeats) add(sessionID string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.sessions[sessionID]++
+}
+
+func (h *heartbeats) remove(sessionID string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.sessions[sessionID]--
+	if h.sessions[sessionID] <= 0 {
+		delete(h.sessions, sessionID)
+	}
+}
+
+func (h *heartbeats) list() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ids := make([]string, 0, len(h.sessions))
+	for id := range h.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// heartbeatLoop refreshes every live session on this instance.
+func (m *Manager) heartbeatLoop() {
+	for {
+		time.Sleep(m.settings.Load().HeartbeatInterval)
+
+		sessionIDs := m.heartbeats.list()
+		if len(sessionIDs) == 0 {
+			continue
+		}
+		if err := m.db.TouchActiveSessions(context.Background(), sessionIDs); err != nil {
+			logger.Error("Error refreshing %d active sessions: %v", len(sessionIDs), err)
+		}
+	}
+}
+
+// reaperLoop removes sessions whose heartbeats stopped and then resyncs each
+// hub's view of users on other instances.
+func (m *Manager) reaperLoop() {
+	for {
+		time.Sleep(m.settings.Load().ReaperInterval)
+
+		ctx := context.Background()
+		reaped, err := m.db.ReapActiveSessions(ctx)
+		if err != nil {
+			logger.Error("Error reaping stale sessions: %v", err)
+			continue
+		}
+		if reaped > 0 {
+			logger.Debug("Reaped %d stale sessions", reaped)
+		}
+
+		for _, hub := range m.liveHubs() {
+			hub.syncPresence(ctx)
+		}
+	}
+}
+
+// syncPresence loads the room's recorded sessions and hands them to the hub.
+func (h *Hub) syncPresence(ctx context.Context) {
+	users, err := h.db.GetActiveUsersInRoom(ctx, h.roomID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error loading presence for room %d: %v", h.roomID, err)
+		return
+	}
+
+	select {
+	case h.presenceSync <- users:
+	case <-h.done:
+	}
+}
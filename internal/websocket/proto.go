@@ -0,0 +1,243 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"chat-app/internal/models"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The chat.v1.proto subprotocol, encoded by hand after api/chat/v1/chat.proto.
+// Field numbers here must match the schema, which proto_test.go checks them
+// against.
+
+var errMalformedProto = errors.New("malformed protobuf frame")
+
+// encodeProto encodes a message as a chat.v1.ServerMessage.
+func encodeProto(msg *models.WebSocketMessage) ([]byte, error) {
+	var b []byte
+	b = appendProtoString(b, 1, string(msg.Type))
+	b = appendProtoInt(b, 2, int64(msg.RoomID))
+	b = appendProtoString(b, 3, msg.RequestID)
+	b = appendProtoInt(b, 4, int64(msg.MessageID))
+	b = appendProtoString(b, 5, msg.ClientID)
+	b = appendProtoInt(b, 6, msg.Seq)
+	b = appendProtoString(b, 7, msg.Text)
+	b = appendProtoString(b, 8, msg.Sender)
+	b = appendProtoInt(b, 9, int64(msg.UserID))
+	b = appendProtoString(b, 10, msg.Username)
+	b = appendProtoString(b, 11, msg.Emoji)
+	b = appendProtoBool(b, 12, msg.Removed)
+	b = appendProtoString(b, 13, msg.Timestamp)
+	for _, user := range msg.Users {
+		b = protowire.AppendTag(b, 14, protowire.BytesType)
+		b = protowire.AppendString(b, user)
+	}
+	for _, user := range msg.ActiveUsers {
+		b = protowire.AppendTag(b, 15, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeProtoActiveUser(user))
+	}
+	b = appendProtoInt(b, 16, int64(msg.UserCount))
+	b = appendProtoString(b, 17, msg.Code)
+	b = appendProtoInt(b, 18, int64(msg.RetryAfter))
+	return b, nil
+}
+
+func encodeProtoActiveUser(user *models.ActiveUser) []byte {
+	var b []byte
+	b = appendProtoInt(b, 1, int64(user.ID))
+	b = appendProtoString(b, 2, user.Username)
+	b = appendProtoString(b, 3, user.Email)
+	b = appendProtoTime(b, 4, user.ConnectedAt)
+	b = appendProtoTime(b, 5, user.LastSeen)
+	b = appendProtoInt(b, 6, int64(user.Connections))
+	b = appendProtoString(b, 7, user.Status)
+	return b
+}
+
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendProtoBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendProtoTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendProtoString(b, num, t.Format(time.RFC3339))
+}
+
+// protoPayloads maps the ClientFrame data fields to their frame types.
+var protoPayloads = map[protowire.Number]models.ClientFrameType{
+	10: models.ClientFrameSendMessage,
+	11: models.ClientFrameEdit,
+	12: models.ClientFrameReact,
+	13: models.ClientFrameTyping,
+	14: models.ClientFrameAck,
+	15: models.ClientFrameSubscribe,
+	16: models.ClientFrameUnsubscribe,
+}
+
+// decodeProtoFrame reads a chat.v1.ClientFrame. The payload is re-encoded as
+// JSON for the request handlers.
+func decodeProtoFrame(message []byte) (*models.ClientFrame, error) {
+	frame := &models.ClientFrame{}
+	var payloadType models.ClientFrameType
+	var payload []byte
+	err := consumeProtoFields(message, func(num protowire.Number, v uint64, s []byte) {
+		switch num {
+		case 1:
+			frame.Version = int(v)
+		case 2:
+			frame.Type = models.ClientFrameType(s)
+		case 3:
+			frame.ID = string(s)
+		case 4:
+			frame.Room = int(v)
+		default:
+			if t, ok := protoPayloads[num]; ok {
+				payloadType, payload = t, s
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if frame.Type == "" {
+		frame.Type = payloadType
+	}
+	if payloadType == "" {
+		return frame, nil
+	}
+	req, err := decodeProtoRequest(payloadType, payload)
+	if err != nil {
+		return nil, err
+	}
+	if frame.Data, err = json.Marshal(req); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func decodeProtoRequest(t models.ClientFrameType, b []byte) (any, error) {
+	switch t {
+	case models.ClientFrameSendMessage:
+		var req models.SendMessageRequest
+		return &req, consumeProtoFields(b, func(num protowire.Number, v uint64, s []byte) {
+			switch num {
+			case 1:
+				req.ClientID = string(s)
+			case 2:
+				req.Text = string(s)
+			}
+		})
+	case models.ClientFrameEdit:
+		var req models.EditRequest
+		return &req, consumeProtoFields(b, func(num protowire.Number, v uint64, s []byte) {
+			switch num {
+			case 1:
+				req.MessageID = int(v)
+			case 2:
+				req.Text = string(s)
+			}
+		})
+	case models.ClientFrameReact:
+		var req models.ReactRequest
+		return &req, consumeProtoFields(b, func(num protowire.Number, v uint64, s []byte) {
+			switch num {
+			case 1:
+				req.MessageID = int(v)
+			case 2:
+				req.Emoji = string(s)
+			case 3:
+				req.Remove = protowire.DecodeBool(v)
+			}
+		})
+	case models.ClientFrameTyping:
+		var req models.TypingRequest
+		return &req, consumeProtoFields(b, func(num protowire.Number, v uint64, s []byte) {
+			if num == 1 {
+				req.Active = protowire.DecodeBool(v)
+			}
+		})
+	case models.ClientFrameAck:
+		var req models.AckRequest
+		return &req, consumeProtoFields(b, func(num protowire.Number, v uint64, s []byte) {
+			if num == 1 {
+				req.MessageID = int(v)
+			}
+		})
+	case models.ClientFrameSubscribe:
+		var req models.SubscribeRequest
+		return &req, consumeProtoFields(b, func(num protowire.Number, v uint64, s []byte) {
+			switch num {
+			case 1:
+				req.RoomID = int(v)
+			case 2:
+				lastSeq := int64(v)
+				req.LastSeq = &lastSeq
+			}
+		})
+	default:
+		var req models.UnsubscribeRequest
+		return &req, consumeProtoFields(b, func(num protowire.Number, v uint64, s []byte) {
+			if num == 1 {
+				req.RoomID = int(v)
+			}
+		})
+	}
+}
+
+// consumeProtoFields calls fn for every field of a message, with the value of
+// varint fields in v and the contents of length-delimited ones in s. Fields of
+// other wire types are skipped.
+func consumeProtoFields(b []byte, fn func(num protowire.Number, v uint64, s []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errMalformedProto
+		}
+		b = b[n:]
+
+		var v uint64
+		var s []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			s, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errMalformedProto
+		}
+		b = b[n:]
+
+		fn(num, v, s)
+	}
+	return nil
+}
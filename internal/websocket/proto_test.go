@@ -0,0 +1,267 @@
+package websocket
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// schema compiles api/chat/v1/chat.proto, which the hand-written codec is
+// checked against.
+func schema(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	compiler := protocompile.Compiler{
+		Resolver: &protocompile.SourceResolver{ImportPaths: []string{"../../api/chat/v1"}},
+	}
+	files, err := compiler.Compile(context.Background(), "chat.proto")
+	if err != nil {
+		t.Fatalf("compile chat.proto: %v", err)
+	}
+	return files[0]
+}
+
+// fields are the values of a schema message by field name. Nested messages
+// are fields too, and repeated ones lists of them.
+type fields map[string]any
+
+func newSchemaMessage(t *testing.T, desc protoreflect.MessageDescriptor, values fields) *dynamicpb.Message {
+	t.Helper()
+	msg := dynamicpb.NewMessage(desc)
+	for name, value := range values {
+		fd := desc.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			t.Fatalf("%s has no field %s", desc.FullName(), name)
+		}
+		switch v := value.(type) {
+		case fields:
+			msg.Set(fd, protoreflect.ValueOfMessage(newSchemaMessage(t, fd.Message(), v)))
+		case []fields:
+			list := msg.Mutable(fd).List()
+			for _, item := range v {
+				list.Append(protoreflect.ValueOfMessage(newSchemaMessage(t, fd.Message(), item)))
+			}
+		case []string:
+			list := msg.Mutable(fd).List()
+			for _, item := range v {
+				list.Append(protoreflect.ValueOfString(item))
+			}
+		default:
+			msg.Set(fd, protoreflect.ValueOf(v))
+		}
+	}
+	return msg
+}
+
+// parseSchemaMessage reads b as desc, failing on fields the schema doesn't
+// know.
+func parseSchemaMessage(t *testing.T, desc protoreflect.MessageDescriptor, b []byte) *dynamicpb.Message {
+	t.Helper()
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(b, msg); err != nil {
+		t.Fatalf("unmarshal %s: %v", desc.FullName(), err)
+	}
+	var unknown func(m protoreflect.Message)
+	unknown = func(m protoreflect.Message) {
+		if len(m.GetUnknown()) > 0 {
+			t.Errorf("%s has fields unknown to the schema: %x", m.Descriptor().FullName(), m.GetUnknown())
+		}
+		m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			switch {
+			case fd.IsList() && fd.Message() != nil:
+				for i := range v.List().Len() {
+					unknown(v.List().Get(i).Message())
+				}
+			case fd.Message() != nil:
+				unknown(v.Message())
+			}
+			return true
+		})
+	}
+	unknown(msg)
+	return msg
+}
+
+func TestProtoServerMessage(t *testing.T) {
+	serverMessage := schema(t).Messages().ByName("ServerMessage")
+	connectedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		msg    *models.WebSocketMessage
+		fields fields
+		golden string
+	}{
+		{
+			name: "message",
+			msg: &models.WebSocketMessage{
+				Type: models.MessageTypeMessage, RoomID: 7, MessageID: 300, ClientID: "c1", Seq: 42,
+				Text: "hi", Sender: "ann", UserID: 3, Username: "ann", Timestamp: "2026-01-02T03:04:05Z",
+			},
+			fields: fields{
+				"type": "message", "room_id": int64(7), "message_id": int64(300), "client_id": "c1", "seq": int64(42),
+				"text": "hi", "sender": "ann", "user_id": int64(3), "username": "ann", "timestamp": "2026-01-02T03:04:05Z",
+			},
+			golden: "0a076d657373616765100720ac022a026331302a3a0268694203616e6e48035203616e6e6a14323032362d30312d30325430333a30343a30355a",
+		},
+		{
+			name:   "reaction",
+			msg:    &models.WebSocketMessage{Type: models.MessageTypeReaction, RoomID: 7, MessageID: 5, UserID: 3, Emoji: "👍", Removed: true},
+			fields: fields{"type": "reaction", "room_id": int64(7), "message_id": int64(5), "user_id": int64(3), "emoji": "👍", "removed": true},
+			golden: "0a087265616374696f6e1007200548035a04f09f918d6001",
+		},
+		{
+			name:   "online_users",
+			msg:    &models.WebSocketMessage{Type: models.MessageTypeOnlineUsers, RoomID: 7, Users: []string{"ann", "bob"}, UserCount: 2},
+			fields: fields{"type": "online_users", "room_id": int64(7), "users": []string{"ann", "bob"}, "user_count": int64(2)},
+			golden: "0a0c6f6e6c696e655f757365727310077203616e6e7203626f62800102",
+		},
+		{
+			name: "presence_update",
+			msg: &models.WebSocketMessage{Type: models.MessageTypePresenceUpdate, RoomID: 7, ActiveUsers: []*models.ActiveUser{{
+				ID: 3, Username: "ann", Email: "ann@example.com", ConnectedAt: connectedAt, LastSeen: connectedAt, Connections: 2, Status: "online",
+			}}},
+			fields: fields{"type": "presence_update", "room_id": int64(7), "active_users": []fields{{
+				"id": int64(3), "username": "ann", "email": "ann@example.com", "connected_at": "2026-01-02T03:04:05Z",
+				"last_seen": "2026-01-02T03:04:05Z", "connections": int64(2), "status": "online",
+			}}},
+			golden: "0a0f70726573656e63655f75706461746510077a4e08031203616e6e1a0f616e6e406578616d706c652e636f6d2214323032362d30312d30325430333a30343a30355a2a14323032362d30312d30325430333a30343a30355a30023a066f6e6c696e65",
+		},
+		{
+			name:   "nack",
+			msg:    &models.WebSocketMessage{Type: models.MessageTypeNack, RequestID: "r1", Code: "rate_limited", RetryAfter: 1500},
+			fields: fields{"type": "nack", "request_id": "r1", "code": "rate_limited", "retry_after_ms": int64(1500)},
+			golden: "0a046e61636b1a0272318a010c726174655f6c696d697465649001dc0b",
+		},
+		{
+			name:   "negative ids",
+			msg:    &models.WebSocketMessage{Type: models.MessageTypeAck, RequestID: "r2", Seq: -1},
+			fields: fields{"type": "ack", "request_id": "r2", "seq": int64(-1)},
+			golden: "0a0361636b1a02723230ffffffffffffffffff01",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeProto(tt.msg)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if hex.EncodeToString(got) != tt.golden {
+				t.Errorf("encoded %x, want %s", got, tt.golden)
+			}
+			want := newSchemaMessage(t, serverMessage, tt.fields)
+			if decoded := parseSchemaMessage(t, serverMessage, got); !proto.Equal(decoded, want) {
+				t.Errorf("decoded with the schema as %v, want %v", decoded, want)
+			}
+		})
+	}
+}
+
+func TestProtoClientFrame(t *testing.T) {
+	clientFrame := schema(t).Messages().ByName("ClientFrame")
+
+	tests := []struct {
+		name   string
+		fields fields
+		golden string
+		want   models.ClientFrame
+	}{
+		{
+			name:   "send_message",
+			fields: fields{"v": int64(1), "id": "f1", "room_id": int64(7), "send_message": fields{"client_id": "c1", "text": "hi"}},
+			golden: "08011a026631200752080a02633112026869",
+			want:   models.ClientFrame{Version: 1, Type: models.ClientFrameSendMessage, ID: "f1", Room: 7, Data: []byte(`{"client_id":"c1","text":"hi"}`)},
+		},
+		{
+			name:   "edit",
+			fields: fields{"v": int64(1), "type": "edit", "id": "f2", "edit": fields{"message_id": int64(300), "text": "fixed"}},
+			golden: "08011204656469741a0266325a0a08ac0212056669786564",
+			want:   models.ClientFrame{Version: 1, Type: models.ClientFrameEdit, ID: "f2", Data: []byte(`{"message_id":300,"text":"fixed"}`)},
+		},
+		{
+			name:   "react",
+			fields: fields{"v": int64(1), "react": fields{"message_id": int64(5), "emoji": "👍", "remove": true}},
+			golden: "0801620a08051204f09f918d1801",
+			want:   models.ClientFrame{Version: 1, Type: models.ClientFrameReact, Data: []byte(`{"message_id":5,"emoji":"👍","remove":true}`)},
+		},
+		{
+			name:   "typing",
+			fields: fields{"v": int64(1), "room_id": int64(7), "typing": fields{"active": true}},
+			golden: "080120076a020801",
+			want:   models.ClientFrame{Version: 1, Type: models.ClientFrameTyping, Room: 7, Data: []byte(`{"active":true}`)},
+		},
+		{
+			name:   "ack",
+			fields: fields{"v": int64(1), "ack": fields{"message_id": int64(300)}},
+			golden: "0801720308ac02",
+			want:   models.ClientFrame{Version: 1, Type: models.ClientFrameAck, Data: []byte(`{"message_id":300}`)},
+		},
+		{
+			name:   "subscribe",
+			fields: fields{"v": int64(1), "id": "f3", "subscribe": fields{"room_id": int64(7)}},
+			golden: "08011a0266337a020807",
+			want:   models.ClientFrame{Version: 1, Type: models.ClientFrameSubscribe, ID: "f3", Data: []byte(`{"room_id":7}`)},
+		},
+		{
+			name:   "subscribe after last_seq 0",
+			fields: fields{"v": int64(1), "subscribe": fields{"room_id": int64(7), "last_seq": int64(0)}},
+			golden: "08017a0408071000",
+			want:   models.ClientFrame{Version: 1, Type: models.ClientFrameSubscribe, Data: []byte(`{"room_id":7,"last_seq":0}`)},
+		},
+		{
+			name:   "unsubscribe",
+			fields: fields{"v": int64(1), "unsubscribe": fields{"room_id": int64(7)}},
+			golden: "08018201020807",
+			want:   models.ClientFrame{Version: 1, Type: models.ClientFrameUnsubscribe, Data: []byte(`{"room_id":7}`)},
+		},
+		{
+			name:   "ping",
+			fields: fields{"v": int64(1), "type": "ping", "id": "f4"},
+			golden: "0801120470696e671a026634",
+			want:   models.ClientFrame{Version: 1, Type: models.ClientFramePing, ID: "f4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			golden, err := hex.DecodeString(tt.golden)
+			if err != nil {
+				t.Fatalf("golden: %v", err)
+			}
+			want := newSchemaMessage(t, clientFrame, tt.fields)
+			if parsed := parseSchemaMessage(t, clientFrame, golden); !proto.Equal(parsed, want) {
+				t.Errorf("golden bytes decode with the schema as %v, want %v", parsed, want)
+			}
+
+			encoded, err := proto.Marshal(want)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			for _, b := range [][]byte{golden, encoded} {
+				got, err := decodeProtoFrame(b)
+				if err != nil {
+					t.Fatalf("decode %x: %v", b, err)
+				}
+				if got.Version != tt.want.Version || got.Type != tt.want.Type || got.ID != tt.want.ID ||
+					got.Room != tt.want.Room || string(got.Data) != string(tt.want.Data) {
+					t.Errorf("decoded %x as %+v (data %s), want %+v (data %s)", b, got, got.Data, tt.want, tt.want.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestProtoMalformedFrame(t *testing.T) {
+	for _, b := range []string{"08", "1a05ff", "820103080a"} {
+		frame, _ := hex.DecodeString(b)
+		if _, err := decodeProtoFrame(frame); err == nil {
+			t.Errorf("decoded malformed frame %s", b)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"chat-app/internal/metrics"
+)
+
+// frameKind says whether a frame may be dropped or merged when its client
+// falls behind. Frames are critical unless marked otherwise.
+type frameKind int
+
+const (
+	frameCritical frameKind = iota
+	// framePresence frames are join and leave events and rosters, all of
+	// which a fresh roster supersedes.
+	framePresence
+	// frameTyping frames list who is typing, which the next one supersedes.
+	frameTyping
+)
+
+// sendQueue buffers a client's outbound frames. Hubs and the client's own
+// goroutines push to it and only WritePump takes from it. It is never closed;
+// closing the client is signalled on its done channel instead, so a send can
+// never race with a close.
+type sendQueue struct {
+	mutex  sync.Mutex
+	frames []Frame
+	limit  int
+	ready  chan struct{}
+}
+
+func newSendQueue(limit int) *sendQueue {
+	return &sendQueue{limit: limit, ready: make(chan struct{}, 1)}
+}
+
+// push appends a frame, reporting false if the queue is full.
+func (q *sendQueue) push(frame Frame) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pushLocked(frame)
+}
+
+func (q *sendQueue) pushLocked(frame Frame) bool {
+	if len(q.frames) >= q.limit {
+		return false
+	}
+	if frame.queued.IsZero() {
+		frame.queued = time.Now()
+	}
+	q.frames = append(q.frames, frame)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// take removes and returns every queued frame.
+func (q *sendQueue) take() []Frame {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	frames := q.frames
+	q.frames = nil
+	return frames
+}
+
+// dropOldest makes room for frame by dropping the oldest queued frame that
+// isn't critical, or frame itself if it isn't critical either. It reports
+// false if frame is critical and only critical frames are queued.
+func (q *sendQueue) dropOldest(frame Frame) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	i := slices.IndexFunc(q.frames, func(queued Frame) bool { return queued.Kind != frameCritical })
+	if i < 0 {
+		if frame.Kind == frameCritical {
+			return false
+		}
+		metrics.WSMessagesDropped.Inc()
+		return true
+	}
+
+	q.frames = slices.Delete(q.frames, i, i+1)
+	metrics.WSMessagesDropped.Inc()
+	return q.pushLocked(frame)
+}
+
+// coalesce makes room for frame by merging the frames of its room that newer
+// state supersedes: queued typing frames collapse into the latest one and
+// presence events into a fresh roster from roster. It reports false if the
+// queue is still full.
+func (q *sendQueue) coalesce(frame Frame, roster func() Frame) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var typing *Frame
+	presence := frame.Kind == framePresence
+	if frame.Kind == frameTyping {
+		typing = &frame
+	}
+
+	kept := q.frames[:0]
+	for _, queued := range q.frames {
+		if queued.Room != frame.Room || queued.Kind == frameCritical {
+			kept = append(kept, queued)
+			continue
+		}
+		if queued.Kind == frameTyping && frame.Kind != frameTyping {
+			typing = &queued
+		}
+		if queued.Kind == framePresence {
+			presence = true
+		}
+		metrics.WSFramesCoalesced.Inc()
+	}
+	clear(q.frames[len(kept):])
+	q.frames = kept
+
+	if presence {
+		if snapshot := roster(); snapshot.Data != nil && !q.pushLocked(snapshot) {
+			return false
+		}
+	}
+	if typing != nil && !q.pushLocked(*typing) {
+		return false
+	}
+	if frame.Kind == frameCritical {
+		return q.pushLocked(frame)
+	}
+	return true
+}
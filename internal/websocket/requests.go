@@ -0,0 +1,259 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"chat-app/internal/database"
+	"chat-app/internal/models"
+	"chat-app/internal/tracing"
+	"chat-app/pkg/logger"
+)
+
+const (
+	// maxEmojiLength bounds a reaction, which may combine several code points.
+	maxEmojiLength    = 32
+	maxClientIDLength = 64
+)
+
+var errMessageNotFound = &requestError{code: models.ErrorCodeNotFound, text: "message not found"}
+
+func okReply(messageID int) *models.WebSocketMessage {
+	return &models.WebSocketMessage{Type: models.MessageTypeReply, MessageID: messageID}
+}
+
+func (c *Client) handleSendMessage(ctx context.Context, sub *subscription, frame *models.ClientFrame) (*models.WebSocketMessage, error) {
+	var req models.SendMessageRequest
+	if err := decodeRequest(frame, &req); err != nil {
+		return nil, err
+	}
+	return c.manager.postMessage(ctx, sub.hub, c.userID, c.username, req)
+}
+
+// postMessage persists a chat message and, once stored, hands it to the
+// room's hub, or to its replacement if the hub stopped in the meantime.
+// The sender is acked with the stored message; a resend of a message already
+// stored under the same client ID is acked again without a second broadcast.
+// WebSocket sends and the REST endpoint both go through it.
+func (m *Manager) postMessage(ctx context.Context, hub *Hub, userID int, username string, req models.SendMessageRequest) (*models.WebSocketMessage, error) {
+	if req.Text == "" {
+		return nil, badRequest("text is required")
+	}
+	if len(req.ClientID) > maxClientIDLength {
+		return nil, badRequest("client_id must be at most %d bytes", maxClientIDLength)
+	}
+
+	msg, created, err := m.db.SaveMessage(ctx, userID, hub.roomID, req.Text, req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := msg.CreatedAt.Format(time.RFC3339)
+
+	if created {
+		// Sending a message ends the user's typing indicator
+		hub.setTyping(ctx, userID, username, false)
+
+		err := m.broadcast(ctx, hub, &models.WebSocketMessage{
+			Type:      models.MessageTypeMessage,
+			MessageID: msg.ID,
+			ClientID:  req.ClientID,
+			Seq:       msg.Seq,
+			Text:      msg.Content,
+			Sender:    username,
+			Timestamp: timestamp,
+		})
+		if err != nil {
+			logger.ErrorContext(ctx, "Error broadcasting message %d to room %d: %v", msg.ID, hub.roomID, err)
+		}
+	}
+
+	return &models.WebSocketMessage{
+		Type:      models.MessageTypeAck,
+		RoomID:    hub.roomID,
+		MessageID: msg.ID,
+		ClientID:  req.ClientID,
+		Seq:       msg.Seq,
+		Timestamp: timestamp,
+	}, nil
+}
+
+// handleEdit replaces the text of one of the user's messages.
+func (c *Client) handleEdit(ctx context.Context, sub *subscription, frame *models.ClientFrame) (*models.WebSocketMessage, error) {
+	var req models.EditRequest
+	if err := decodeRequest(frame, &req); err != nil {
+		return nil, err
+	}
+	if req.MessageID <= 0 || req.Text == "" {
+		return nil, badRequest("message_id and text are required")
+	}
+
+	msg, err := c.db.EditMessage(ctx, req.MessageID, c.userID, sub.roomID, req.Text)
+	if errors.Is(err, database.ErrMessageNotFound) {
+		return nil, errMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sub.hub.broadcast(ctx, &models.WebSocketMessage{
+		Type:      models.MessageTypeMessageEdited,
+		MessageID: msg.ID,
+		Text:      msg.Content,
+		Sender:    c.username,
+		Timestamp: msg.EditedAt.Format(time.RFC3339),
+	})
+	return okReply(msg.ID), nil
+}
+
+// handleReact adds or removes the user's reaction to a message in the room.
+func (c *Client) handleReact(ctx context.Context, sub *subscription, frame *models.ClientFrame) (*models.WebSocketMessage, error) {
+	var req models.ReactRequest
+	if err := decodeRequest(frame, &req); err != nil {
+		return nil, err
+	}
+	if req.MessageID <= 0 || req.Emoji == "" {
+		return nil, badRequest("message_id and emoji are required")
+	}
+	if len(req.Emoji) > maxEmojiLength || !utf8.ValidString(req.Emoji) {
+		return nil, badRequest("invalid emoji")
+	}
+
+	err := c.db.SetReaction(ctx, req.MessageID, c.userID, sub.roomID, req.Emoji, !req.Remove)
+	if errors.Is(err, database.ErrMessageNotFound) {
+		return nil, errMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sub.hub.broadcast(ctx, &models.WebSocketMessage{
+		Type:      models.MessageTypeReaction,
+		MessageID: req.MessageID,
+		UserID:    c.userID,
+		Username:  c.username,
+		Emoji:     req.Emoji,
+		Removed:   req.Remove,
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	return okReply(req.MessageID), nil
+}
+
+func (c *Client) handleTypingRequest(ctx context.Context, sub *subscription, frame *models.ClientFrame) (*models.WebSocketMessage, error) {
+	var req models.TypingRequest
+	if err := decodeRequest(frame, &req); err != nil {
+		return nil, err
+	}
+
+	c.handleTyping(sub, req.Active)
+	return okReply(0), nil
+}
+
+// handleAck accepts a client's acknowledgement of a delivered message. Acks
+// carry no server-side state yet and are never answered.
+func (c *Client) handleAck(ctx context.Context, sub *subscription, frame *models.ClientFrame) (*models.WebSocketMessage, error) {
+	var req models.AckRequest
+	if err := decodeRequest(frame, &req); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (c *Client) handlePing(ctx context.Context, sub *subscription, frame *models.ClientFrame) (*models.WebSocketMessage, error) {
+	return &models.WebSocketMessage{Type: models.MessageTypePong}, nil
+}
+
+// handleSubscribe joins a multiplexed connection to a room the user may
+// access. A legacy connection serves a single room, so it can only confirm
+// its own.
+func (c *Client) handleSubscribe(ctx context.Context, sub *subscription, frame *models.ClientFrame) (*models.WebSocketMessage, error) {
+	var req models.SubscribeRequest
+	if err := decodeRequest(frame, &req); err != nil {
+		return nil, err
+	}
+	if !c.multiplexed {
+		if req.RoomID != c.defaultRoom {
+			return nil, &requestError{code: models.ErrorCodeNotSupported, text: "a connection can only subscribe to its own room"}
+		}
+		return &models.WebSocketMessage{Type: models.MessageTypeReply, RoomID: req.RoomID}, nil
+	}
+	if req.RoomID <= 0 {
+		return nil, badRequest("room_id is required")
+	}
+	lastSeq := int64(-1)
+	if req.LastSeq != nil {
+		if *req.LastSeq < 0 {
+			return nil, badRequest("last_seq must not be negative")
+		}
+		lastSeq = *req.LastSeq
+	}
+
+	canAccess, err := c.access.CanUserAccessRoom(ctx, c.userID, req.RoomID)
+	if err != nil {
+		return nil, &requestError{code: models.ErrorCodeNotFound, text: "room not found"}
+	}
+	if !canAccess {
+		return nil, &requestError{code: models.ErrorCodeForbidden, text: "not a member of this room"}
+	}
+
+	if err := c.Subscribe(req.RoomID, lastSeq); err != nil {
+		if errors.Is(err, ErrTooManySubscriptions) {
+			return nil, &requestError{code: models.ErrorCodeSubscriptionLimit, text: fmt.Sprintf("at most %d rooms per connection", c.cfg.MaxSubscriptions)}
+		}
+		return nil, err
+	}
+	logger.InfoContext(ctx, "User %s subscribed to room %d", c.username, req.RoomID)
+	return &models.WebSocketMessage{Type: models.MessageTypeReply, RoomID: req.RoomID}, nil
+}
+
+// handleUnsubscribe leaves a room on a multiplexed connection. Live frames
+// for the room already queued are dropped.
+func (c *Client) handleUnsubscribe(ctx context.Context, sub *subscription, frame *models.ClientFrame) (*models.WebSocketMessage, error) {
+	var req models.UnsubscribeRequest
+	if err := decodeRequest(frame, &req); err != nil {
+		return nil, err
+	}
+	if !c.multiplexed {
+		return nil, &requestError{code: models.ErrorCodeNotSupported, text: "a connection can only leave its room by closing"}
+	}
+	if !c.unsubscribe(req.RoomID) {
+		return nil, &requestError{code: models.ErrorCodeNotSubscribed, text: "not subscribed to this room"}
+	}
+	return &models.WebSocketMessage{Type: models.MessageTypeReply, RoomID: req.RoomID}, nil
+}
+
+// broadcast hands an event to the hub for every client in the room. It
+// reports false if the hub had stopped.
+func (h *Hub) broadcast(ctx context.Context, msg *models.WebSocketMessage) bool {
+	msg.RoomID = h.roomID
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error marshaling %s event: %v", msg.Type, err)
+		return true
+	}
+
+	// Time spent waiting for the hub to accept the event
+	_, enqueue := tracing.Tracer().Start(ctx, "hub.enqueue")
+	defer enqueue.End()
+	select {
+	case h.Broadcast <- Frame{Ctx: ctx, Data: data, Seq: msg.Seq}:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// broadcast hands a stored message to the room's hub. A hub that stopped in
+// the meantime is replaced, so the message still reaches the room.
+func (m *Manager) broadcast(ctx context.Context, hub *Hub, msg *models.WebSocketMessage) error {
+	for !hub.broadcast(ctx, msg) {
+		var err error
+		if hub, err = m.GetHubForRoom(ctx, hub.roomID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/internal/metrics"
+	"chat-app/internal/models"
+	"chat-app/internal/ratelimit"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrPollNotFound is returned for a poll ID that is unknown, expired or
+// belongs to another user or room.
+var ErrPollNotFound = errors.New("poll session not found")
+
+// ServeEvents streams a room to w as Server-Sent Events until the request
+// ends or the client is closed, which draining the room's hub on shutdown
+// does after a reconnect hint. Messages numbered after lastSeq are sent
+// first, or the recent history when lastSeq is negative. Access must already
+// have been checked; nothing is written if an error is returned.
+func (m *Manager) ServeEvents(w http.ResponseWriter, r *http.Request, roomID, userID int, username, authSessionID string, lastSeq int64) error {
+	// The request's cancellation ends the stream below rather than through
+	// the context every frame carries
+	ctx := context.WithoutCancel(r.Context())
+	client, err := newClient(ctx, m, newSSETransport(w, m.config().WriteTimeout), "sse", userID, username, authSessionID)
+	if err != nil {
+		return err
+	}
+	if err := client.Subscribe(roomID, lastSeq); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	http.NewResponseController(w).Flush()
+
+	metrics.StreamClients.WithLabelValues(client.kind).Inc()
+	defer metrics.StreamClients.WithLabelValues(client.kind).Dec()
+
+	stop := context.AfterFunc(r.Context(), func() {
+		client.close(websocket.CloseGoingAway, "")
+	})
+	defer stop()
+
+	client.WritePump()
+	client.close(websocket.CloseNormalClosure, "")
+	client.unsubscribeAll()
+	return nil
+}
+
+// pollSession is a long-poll client kept registered between polls so it
+// misses nothing while the next poll is on its way.
+type pollSession struct {
+	client    *Client
+	transport *pollTransport
+	roomID    int
+}
+
+// Poll waits for a room's events on a long-poll session. Without a pollID it
+// opens a session starting after lastSeq, or with the recent history when
+// lastSeq is negative; access must then already have been checked.
+func (m *Manager) Poll(ctx context.Context, pollID string, roomID, userID int, username, authSessionID string, lastSeq int64) (*models.PollResponse, error) {
+	var session *pollSession
+	if pollID == "" {
+		var err error
+		if session, err = m.openPoll(ctx, roomID, userID, username, authSessionID, lastSeq); err != nil {
+			return nil, err
+		}
+	} else {
+		m.mutex.Lock()
+		session = m.polls[pollID]
+		m.mutex.Unlock()
+		if session == nil || session.client.userID != userID || session.roomID != roomID {
+			return nil, ErrPollNotFound
+		}
+	}
+
+	events, closed := session.transport.wait(ctx, m.config().PollTimeout)
+	if events == nil {
+		events = []json.RawMessage{}
+	}
+	return &models.PollResponse{PollID: session.client.sessionID, Events: events, Closed: closed}, nil
+}
+
+// PollTimeout returns how long answering a poll may take, including writing
+// the response.
+func (m *Manager) PollTimeout() time.Duration {
+	cfg := m.config()
+	return cfg.PollTimeout + cfg.WriteTimeout
+}
+
+func (m *Manager) openPoll(ctx context.Context, roomID, userID int, username, authSessionID string, lastSeq int64) (*pollSession, error) {
+	cfg := m.config()
+	t := newPollTransport(cfg.SendBuffer, cfg.PongWait)
+	client, err := newClient(context.WithoutCancel(ctx), m, t, "poll", userID, username, authSessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Subscribe(roomID, lastSeq); err != nil {
+		return nil, err
+	}
+
+	session := &pollSession{client: client, transport: t, roomID: roomID}
+	m.mutex.Lock()
+	m.polls[client.sessionID] = session
+	m.mutex.Unlock()
+	metrics.StreamClients.WithLabelValues(client.kind).Inc()
+
+	// The session ends when it is closed, falls too far behind or stops
+	// being polled
+	go func() {
+		client.WritePump()
+		client.close(websocket.CloseNormalClosure, "")
+		client.unsubscribeAll()
+
+		m.mutex.Lock()
+		delete(m.polls, client.sessionID)
+		m.mutex.Unlock()
+		metrics.StreamClients.WithLabelValues(client.kind).Dec()
+	}()
+	return session, nil
+}
+
+// PostMessage sends a chat message to a room on behalf of a user exactly as a
+// WebSocket send would: the user's flood limit for the room applies and the
+// message is stored and broadcast the same way. Access must already have been
+// checked.
+func (m *Manager) PostMessage(ctx context.Context, roomID, userID int, username string, req models.SendMessageRequest) (*models.WebSocketMessage, error) {
+	// The broadcast is published after the request may have ended
+	ctx = context.WithoutCancel(ctx)
+	hub, err := m.GetHubForRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, retryAfter := hub.flood.userBucket(userID).take(time.Now()); !ok {
+		metrics.WSMessagesRateLimited.Inc()
+		return nil, &ratelimit.LimitedError{RetryAfter: retryAfter}
+	}
+	return m.postMessage(ctx, hub, userID, username, req)
+}
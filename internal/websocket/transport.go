@@ -0,0 +1,258 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"chat-app/internal/metrics"
+	"chat-app/pkg/logger"
+
+	"github.com/gorilla/websocket"
+)
+
+// transport carries a client's frames to the peer. Hubs only ever queue
+// frames on the client, so they work the same whether it is a WebSocket, an
+// SSE stream or a long-poll session. Only WritePump calls the methods, except
+// abort.
+type transport interface {
+	writeFrame(frame Frame) error
+	// ping keeps an idle peer alive, or reports that it is gone.
+	ping() error
+	// writeClose tells the peer the client is closing, where it can be told.
+	writeClose(code int, reason string) error
+	// abort drops the peer at once. It may be called from any goroutine.
+	abort(code int, reason string)
+	// release frees the transport once WritePump is done with it.
+	release()
+}
+
+type wsTransport struct {
+	ctx          context.Context
+	conn         *websocket.Conn
+	messageType  int
+	writeTimeout time.Duration
+	// deflate is set when the connection negotiated permessage-deflate;
+	// frames of at least compressMin bytes are then compressed.
+	deflate     *deflateConn
+	compressMin int
+}
+
+func (t *wsTransport) writeFrame(frame Frame) error {
+	t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	if t.deflate == nil {
+		return t.conn.WriteMessage(t.messageType, frame.Data)
+	}
+
+	compress := len(frame.Data) >= t.compressMin
+	t.conn.EnableWriteCompression(compress)
+	if !compress {
+		return t.conn.WriteMessage(t.messageType, frame.Data)
+	}
+
+	// Pings and pongs written meanwhile count towards the frame, which only
+	// makes the savings look smaller
+	before := t.deflate.written.Load()
+	if err := t.conn.WriteMessage(t.messageType, frame.Data); err != nil {
+		return err
+	}
+	written := t.deflate.written.Load() - before
+	metrics.WSCompressedFrames.Inc()
+	metrics.WSCompressionInputBytes.Add(float64(len(frame.Data)))
+	metrics.WSCompressionOutputBytes.Add(float64(written))
+	if saved := int64(len(frame.Data)) - written; saved > 0 {
+		metrics.WSCompressionSavedBytes.Add(float64(saved))
+	}
+	return nil
+}
+
+func (t *wsTransport) ping() error {
+	t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	return t.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (t *wsTransport) writeClose(code int, reason string) error {
+	msg := []byte{}
+	if code != websocket.CloseNormalClosure || reason != "" {
+		msg = websocket.FormatCloseMessage(code, reason)
+	}
+	t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	return t.conn.WriteMessage(websocket.CloseMessage, msg)
+}
+
+// abort writes the close frame concurrently with WritePump, which gorilla
+// allows for control frames, and then closes the connection.
+func (t *wsTransport) abort(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := t.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(t.writeTimeout)); err != nil {
+		logger.ErrorContext(t.ctx, "Error sending close frame: %v", err)
+	}
+	t.conn.Close()
+}
+
+func (t *wsTransport) release() {
+	t.conn.Close()
+}
+
+// sseTransport writes frames as Server-Sent Events. Messages carry their
+// sequence number as the event ID, so a reconnecting EventSource resumes
+// after the last one it saw.
+type sseTransport struct {
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+}
+
+func newSSETransport(w http.ResponseWriter, writeTimeout time.Duration) *sseTransport {
+	return &sseTransport{w: w, rc: http.NewResponseController(w), writeTimeout: writeTimeout}
+}
+
+func (t *sseTransport) writeFrame(frame Frame) error {
+	if frame.Seq != 0 {
+		fmt.Fprintf(t.w, "id: %d\n", frame.Seq)
+	}
+	return t.write("data: %s\n\n", frame.Data)
+}
+
+func (t *sseTransport) ping() error {
+	return t.write(": ping\n\n")
+}
+
+func (t *sseTransport) writeClose(code int, reason string) error {
+	data, _ := json.Marshal(struct {
+		Code   int    `json:"code"`
+		Reason string `json:"reason,omitempty"`
+	}{code, reason})
+	return t.write("event: close\ndata: %s\n\n", data)
+}
+
+// write sends one event. The deadline is extended per write so the server's
+// write timeout doesn't end healthy streams.
+func (t *sseTransport) write(format string, args ...any) error {
+	t.rc.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	if _, err := fmt.Fprintf(t.w, format, args...); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+func (t *sseTransport) abort(code int, reason string) {}
+
+func (t *sseTransport) release() {}
+
+var (
+	errPollBacklog = errors.New("poll backlog full")
+	errPollExpired = errors.New("poll session expired")
+)
+
+// pollTransport buffers frames until the next poll collects them. A session
+// nobody polls for idleTimeout is considered gone, like a WebSocket that
+// stopped answering pings.
+type pollTransport struct {
+	mutex       sync.Mutex
+	frames      []json.RawMessage
+	limit       int
+	ready       chan struct{}
+	closed      bool
+	waiting     int
+	lastPoll    time.Time
+	idleTimeout time.Duration
+}
+
+func newPollTransport(limit int, idleTimeout time.Duration) *pollTransport {
+	return &pollTransport{
+		limit:       limit,
+		ready:       make(chan struct{}, 1),
+		lastPoll:    time.Now(),
+		idleTimeout: idleTimeout,
+	}
+}
+
+func (t *pollTransport) writeFrame(frame Frame) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.frames) >= t.limit {
+		return errPollBacklog
+	}
+	t.frames = append(t.frames, frame.Data)
+	t.notify()
+	return nil
+}
+
+func (t *pollTransport) ping() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.waiting == 0 && time.Since(t.lastPoll) > t.idleTimeout {
+		return errPollExpired
+	}
+	return nil
+}
+
+func (t *pollTransport) writeClose(code int, reason string) error {
+	t.finish()
+	return nil
+}
+
+func (t *pollTransport) abort(code int, reason string) {}
+
+func (t *pollTransport) release() {
+	t.finish()
+}
+
+// finish marks the session closed and wakes any waiting poll.
+func (t *pollTransport) finish() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.closed = true
+	t.notify()
+}
+
+// notify wakes a waiting poll; callers hold the mutex.
+func (t *pollTransport) notify() {
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+}
+
+// wait returns the buffered frames, waiting up to timeout for the first one,
+// and whether the session has closed.
+func (t *pollTransport) wait(ctx context.Context, timeout time.Duration) ([]json.RawMessage, bool) {
+	t.mutex.Lock()
+	t.waiting++
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		t.waiting--
+		t.lastPoll = time.Now()
+		t.mutex.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		t.mutex.Lock()
+		if len(t.frames) > 0 || t.closed {
+			frames := t.frames
+			t.frames = nil
+			closed := t.closed
+			t.mutex.Unlock()
+			return frames, closed
+		}
+		t.mutex.Unlock()
+
+		select {
+		case <-t.ready:
+		case <-timer.C:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
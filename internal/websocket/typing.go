@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/pkg/logger"
+)
+
+// typingUpdate reports that a user started or stopped composing a message.
+type typingUpdate struct {
+	ctx      context.Context
+	userID   int
+	username string
+	started  bool
+}
+
+type typingEntry struct {
+	username string
+	expires  time.Time
+}
+
+// typingTracker holds who is composing in a room. Entries expire on their own
+// so a client that disappears mid-sentence doesn't leave its user typing
+// forever. It is owned by the hub goroutine.
+type typingTracker struct {
+	users map[int]*typingEntry
+	timer *time.Timer
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{users: make(map[int]*typingEntry)}
+}
+
+// start marks the user as typing until expires and reports whether they were
+// not typing before.
+func (t *typingTracker) start(userID int, username string, expires time.Time) bool {
+	if entry, ok := t.users[userID]; ok {
+		entry.expires = expires
+		return false
+	}
+	t.users[userID] = &typingEntry{username: username, expires: expires}
+	return true
+}
+
+// stop reports whether the user was typing.
+func (t *typingTracker) stop(userID int) bool {
+	if _, ok := t.users[userID]; !ok {
+		return false
+	}
+	delete(t.users, userID)
+	return true
+}
+
+// expire drops the entries that expired by now and reports whether any did.
+func (t *typingTracker) expire(now time.Time) bool {
+	expired := false
+	for userID, entry := range t.users {
+		if !entry.expires.After(now) {
+			delete(t.users, userID)
+			expired = true
+		}
+	}
+	return expired
+}
+
+// schedule returns a channel that fires when the next entry expires, or nil
+// while nobody is typing.
+func (t *typingTracker) schedule() <-chan time.Time {
+	var next time.Time
+	for _, entry := range t.users {
+		if next.IsZero() || entry.expires.Before(next) {
+			next = entry.expires
+		}
+	}
+	if next.IsZero() {
+		return nil
+	}
+
+	if t.timer == nil {
+		t.timer = time.NewTimer(time.Until(next))
+	} else {
+		t.timer.Reset(time.Until(next))
+	}
+	return t.timer.C
+}
+
+// names lists the usernames of everyone typing except the given user.
+func (t *typingTracker) names(except int) []string {
+	names := make([]string, 0, len(t.users))
+	for userID, entry := range t.users {
+		if userID != except {
+			names = append(names, entry.username)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Client) handleTyping(sub *subscription, started bool) {
+	sub.hub.setTyping(c.ctx, c.userID, c.username, started)
+}
+
+// setTyping forwards a typing change to the hub unless the user's typing
+// frames are being throttled.
+func (h *Hub) setTyping(ctx context.Context, userID int, username string, started bool) {
+	if !h.flood.allowTyping(userID, started, h.config().TypingThrottle) {
+		return
+	}
+
+	update := typingUpdate{ctx: ctx, userID: userID, username: username, started: started}
+	select {
+	case h.typingUpdates <- update:
+	case <-h.done:
+	}
+}
+
+// applyTyping records a typing change and tells the room's clients who is
+// typing if that changed. Local changes, including refreshes, are also
+// published so other instances extend their expiry.
+func (h *Hub) applyTyping(update typingUpdate, local bool) {
+	var changed bool
+	msgType := models.MessageTypeTypingStopped
+	if update.started {
+		msgType = models.MessageTypeTypingStarted
+		changed = h.typing.start(update.userID, update.username, time.Now().Add(h.config().TypingTimeout))
+	} else {
+		changed = h.typing.stop(update.userID)
+	}
+
+	if local {
+		h.publish(update.ctx, userEvent(msgType, h.roomID, update.userID, update.username))
+	}
+	if changed && !h.draining {
+		h.broadcastTyping(update.ctx, update.userID)
+	}
+}
+
+// broadcastTyping sends every client the users typing in the room, leaving
+// out the client's own user. The clients of changedUser are skipped since
+// their view did not change.
+func (h *Hub) broadcastTyping(ctx context.Context, changedUser int) {
+	payloads := make(map[int][]byte)
+	payload := func(userID int) []byte {
+		if data, ok := payloads[userID]; ok {
+			return data
+		}
+		data, err := json.Marshal(models.WebSocketMessage{
+			Type:      models.MessageTypeTyping,
+			RoomID:    h.roomID,
+			Users:     h.typing.names(userID),
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			logger.ErrorContext(ctx, "Error marshaling typing update: %v", err)
+		}
+		payloads[userID] = data
+		return data
+	}
+
+	h.broadcastEach(func(client *Client) Frame {
+		if client.userID == changedUser {
+			return Frame{}
+		}
+		// Clients whose user isn't typing all get the same list
+		userID := client.userID
+		if _, ok := h.typing.users[userID]; !ok {
+			userID = 0
+		}
+		return Frame{Ctx: ctx, Data: payload(userID), Kind: frameTyping}
+	})
+}
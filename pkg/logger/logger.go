@@ -1,57 +1,165 @@
 package logger
 
 import (
-	"log"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 )
 
-type Logger struct {
-	infoLogger  *log.Logger
-	errorLogger *log.Logger
-	debugLogger *log.Logger
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+var (
+	level         = new(slog.LevelVar)
+	defaultLogger = slog.New(newContextHandler(slog.NewTextHandler(os.Stdout, handlerOptions())))
+)
+
+func handlerOptions() *slog.HandlerOptions {
+	return &slog.HandlerOptions{
+		AddSource: true,
+		Level:     level,
+	}
 }
 
-func New() *Logger {
-	return &Logger{
-		infoLogger:  log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile),
-		errorLogger: log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile),
-		debugLogger: log.New(os.Stdout, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile),
+// Setup configures the global logger. Format is "text" or "json".
+func Setup(levelName, format string, w io.Writer) error {
+	if err := SetLevel(levelName); err != nil {
+		return err
 	}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, handlerOptions())
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOptions())
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	defaultLogger = slog.New(newContextHandler(handler))
+	slog.SetDefault(defaultLogger)
+	return nil
 }
 
-func (l *Logger) Info(format string, v ...interface{}) {
-	l.infoLogger.Printf(format, v...)
+// SetLevel changes the minimum level at runtime.
+func SetLevel(levelName string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(levelName)); err != nil {
+		return fmt.Errorf("unknown log level %q", levelName)
+	}
+	level.Set(l)
+	return nil
 }
 
-func (l *Logger) Error(format string, v ...interface{}) {
-	l.errorLogger.Printf(format, v...)
+func GetLevel() string {
+	return strings.ToLower(level.Level().String())
 }
 
-func (l *Logger) Debug(format string, v ...interface{}) {
-	l.debugLogger.Printf(format, v...)
+// Default returns the underlying structured logger for callers that want to
+// attach fields.
+func Default() *slog.Logger {
+	return defaultLogger
 }
 
-func (l *Logger) Fatal(format string, v ...interface{}) {
-	l.errorLogger.Printf(format, v...)
-	os.Exit(1)
+// WithRequestID stores the request ID in ctx; every record logged with that
+// context carries it.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
 }
 
-// Global logger instance
-var GlobalLogger = New()
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+func NewRequestID() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%x", bytes)
+}
+
+// contextHandler adds the request ID from the context to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func newContextHandler(handler slog.Handler) *contextHandler {
+	return &contextHandler{Handler: handler}
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		r.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return newContextHandler(h.Handler.WithAttrs(attrs))
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return newContextHandler(h.Handler.WithGroup(name))
+}
+
+// logf formats the message and reports the caller's location rather than
+// this package's.
+func logf(ctx context.Context, l slog.Level, format string, v ...interface{}) {
+	if !defaultLogger.Enabled(ctx, l) {
+		return
+	}
+
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), l, fmt.Sprintf(format, v...), pcs[0])
+	_ = defaultLogger.Handler().Handle(ctx, r)
+}
 
 // Convenience functions
 func Info(format string, v ...interface{}) {
-	GlobalLogger.Info(format, v...)
+	logf(context.Background(), slog.LevelInfo, format, v...)
+}
+
+func Warn(format string, v ...interface{}) {
+	logf(context.Background(), slog.LevelWarn, format, v...)
 }
 
 func Error(format string, v ...interface{}) {
-	GlobalLogger.Error(format, v...)
+	logf(context.Background(), slog.LevelError, format, v...)
 }
 
 func Debug(format string, v ...interface{}) {
-	GlobalLogger.Debug(format, v...)
+	logf(context.Background(), slog.LevelDebug, format, v...)
 }
 
 func Fatal(format string, v ...interface{}) {
-	GlobalLogger.Fatal(format, v...)
-}
\ No newline at end of file
+	logf(context.Background(), slog.LevelError, format, v...)
+	os.Exit(1)
+}
+
+// Context-aware variants include the request ID when present
+func InfoContext(ctx context.Context, format string, v ...interface{}) {
+	logf(ctx, slog.LevelInfo, format, v...)
+}
+
+func WarnContext(ctx context.Context, format string, v ...interface{}) {
+	logf(ctx, slog.LevelWarn, format, v...)
+}
+
+func ErrorContext(ctx context.Context, format string, v ...interface{}) {
+	logf(ctx, slog.LevelError, format, v...)
+}
+
+func DebugContext(ctx context.Context, format string, v ...interface{}) {
+	logf(ctx, slog.LevelDebug, format, v...)
+}
@@ -4,25 +4,88 @@ CREATE TABLE IF NOT EXISTS users (
     username TEXT NOT NULL UNIQUE,
     created_at TIMESTAMP DEFAULT NOW(),
     email TEXT UNIQUE,
-    password_hash TEXT
+    password_hash TEXT,
+    totp_secret TEXT,
+    totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    totp_last_counter BIGINT,
+    is_admin BOOLEAN NOT NULL DEFAULT false,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    must_reset_password BOOLEAN NOT NULL DEFAULT false
 );
 
+-- Columns added after the table was first created
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_counter BIGINT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS must_reset_password BOOLEAN NOT NULL DEFAULT false;
+
 -- rooms table
 CREATE TABLE IF NOT EXISTS rooms (
     id SERIAL PRIMARY KEY,
     name TEXT NOT NULL UNIQUE,
     created_at TIMESTAMP DEFAULT NOW(),
     is_public boolean default true,
-    owner_id INT REFERENCES users(id)
+    owner_id INT REFERENCES users(id),
+    message_rate REAL,
+    message_burst INT,
+    slow_consumer_policy TEXT,
+    last_seq BIGINT NOT NULL DEFAULT 0
 );
 
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS message_rate REAL;
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS message_burst INT;
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS slow_consumer_policy TEXT;
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+
 -- messages table
 CREATE TABLE IF NOT EXISTS messages (
     id SERIAL PRIMARY KEY,
     user_id INT REFERENCES users(id) ON DELETE CASCADE,
     room_id INT REFERENCES rooms(id) ON DELETE CASCADE,
     content TEXT NOT NULL,
-    created_at TIMESTAMP DEFAULT NOW()
+    created_at TIMESTAMP DEFAULT NOW(),
+    edited_at TIMESTAMP,
+    client_id TEXT,
+    seq BIGINT NOT NULL
+);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_id TEXT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+-- Number messages stored before seq existed in the order they were posted,
+-- after any already numbered, and move each room's counter past them
+UPDATE messages m SET seq = numbered.seq
+FROM (
+    SELECT id, COALESCE((SELECT MAX(seq) FROM messages WHERE room_id = unnumbered.room_id), 0)
+        + row_number() OVER (PARTITION BY room_id ORDER BY id) AS seq
+    FROM messages unnumbered
+    WHERE seq IS NULL
+) numbered
+WHERE m.id = numbered.id;
+
+UPDATE rooms r SET last_seq = numbered.max_seq
+FROM (SELECT room_id, MAX(seq) AS max_seq FROM messages GROUP BY room_id) numbered
+WHERE r.id = numbered.room_id AND r.last_seq < numbered.max_seq;
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+
+-- seq numbers each room's messages in order, for replay after a reconnect
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_room_seq ON messages (room_id, seq);
+
+-- Resent messages carry the same client_id and are stored once per room
+DROP INDEX IF EXISTS idx_messages_client_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_room_client_id ON messages (user_id, room_id, client_id);
+
+-- message_reactions holds one row per user and emoji on a message
+CREATE TABLE IF NOT EXISTS message_reactions (
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    emoji TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id, emoji)
 );
 
 CREATE TABLE IF NOT EXISTS memberships (
@@ -42,3 +105,65 @@ CREATE TABLE IF NOT EXISTS active_sessions (
     last_seen TIMESTAMP DEFAULT NOW(),
     UNIQUE(user_id, room_id, session_id)
 );
+
+-- recovery_codes table for two-factor authentication fallback
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    UNIQUE(user_id, code_hash)
+);
+
+-- login_attempts table for sliding-window rate limiting
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    key TEXT NOT NULL,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_key_time ON login_attempts (key, attempted_at);
+
+-- login_lockouts table for temporary account and IP blocks
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    key TEXT PRIMARY KEY,
+    locked_until TIMESTAMPTZ NOT NULL
+);
+
+-- user_sessions table tracks issued login tokens per device
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id TEXT PRIMARY KEY,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    device_name TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions (user_id);
+
+-- audit_events table records security and administrative actions
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INT REFERENCES users(id) ON DELETE SET NULL,
+    actor_name TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL DEFAULT '',
+    target_id TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    metadata JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action, created_at);
+
+-- cluster_payloads holds hub events too large for a NOTIFY payload
+CREATE TABLE IF NOT EXISTS cluster_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    payload BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);